@@ -0,0 +1,223 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/perf"
+	"github.com/leaktk/gcs-filter/pipeline"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// checkpointInterval is how many processed objects go by between saves
+const checkpointInterval = 100
+
+// ProcessFunc handles the generation of an object described by the
+// attributes from the listing
+type ProcessFunc func(ctx context.Context, object *store.ObjectAttrs) (*pipeline.ScanResult, error)
+
+// Options control what a backfill scans and how
+type Options struct {
+	BucketName string
+	Prefix     string
+	Workers    int
+	// CheckpointPath is where progress is saved. If empty, progress isn't
+	// saved and the backfill can't be resumed.
+	CheckpointPath string
+}
+
+type job struct {
+	seq    int
+	object *store.ObjectAttrs
+}
+
+// progress tracks which objects are done so the checkpoint offset only moves
+// past objects that have been processed even though workers finish out of
+// order. The offset never moves past an object that failed so that it's
+// retried when the backfill is resumed.
+type progress struct {
+	mu         sync.Mutex
+	checkpoint *Checkpoint
+	names      map[int]string
+	done       map[int]bool
+	next       int
+	// failedSeq is the first object that failed or -1 if none have
+	failedSeq int
+	sinceSave int
+	path      string
+}
+
+// blocked reports whether the offset can't move past seq in this run
+func (p *progress) blocked(seq int) bool {
+	return p.failedSeq >= 0 && seq >= p.failedSeq
+}
+
+func (p *progress) add(j job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.blocked(j.seq) {
+		p.names[j.seq] = j.object.Name
+	}
+}
+
+func (p *progress) finish(j job, outcome pipeline.Outcome, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.checkpoint.Scanned++
+	if outcome != "" {
+		p.checkpoint.Outcomes[outcome]++
+	}
+
+	if failed {
+		p.checkpoint.Failed = append(p.checkpoint.Failed, j.object.Name)
+		if !p.blocked(j.seq) {
+			p.failedSeq = j.seq
+
+			// Nothing at or after the first failure is needed for the
+			// offset anymore
+			for seq := range p.names {
+				if p.blocked(seq) {
+					delete(p.names, seq)
+					delete(p.done, seq)
+				}
+			}
+		}
+	}
+
+	if !p.blocked(j.seq) {
+		p.done[j.seq] = true
+	}
+
+	for p.done[p.next] {
+		p.checkpoint.Offset = p.names[p.next]
+		delete(p.done, p.next)
+		delete(p.names, p.next)
+		p.next++
+	}
+
+	p.sinceSave++
+	if p.sinceSave >= checkpointInterval {
+		p.sinceSave = 0
+		if err := p.checkpoint.save(p.path); err != nil {
+			logging.Error("could not save checkpoint: %w", err)
+		}
+	}
+}
+
+func (p *progress) save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checkpoint.save(p.path)
+}
+
+// Run lists every object in the bucket under the prefix and passes its
+// current generation to process using a bounded pool of workers. If a
+// checkpoint exists at opts.CheckpointPath, listing resumes after the
+// checkpoint's offset.
+func Run(ctx context.Context, objects store.Lister, opts Options, process ProcessFunc) (*Checkpoint, error) {
+	defer perf.Timer("Backfill")()
+
+	if opts.Workers < 1 {
+		return nil, errors.New("at least one worker is required")
+	}
+
+	checkpoint, err := loadCheckpoint(opts.CheckpointPath, opts.BucketName, opts.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not load checkpoint: %w", err)
+	}
+
+	// The objects that failed before are after the offset and are retried
+	checkpoint.Failed = nil
+	tracker := &progress{
+		checkpoint: checkpoint,
+		names:      make(map[int]string),
+		done:       make(map[int]bool),
+		failedSeq:  -1,
+		path:       opts.CheckpointPath,
+	}
+
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+
+	for range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				result, err := process(ctx, j.object)
+				if err == nil && result.Outcome == pipeline.OutcomeScanError {
+					err = result.ScanErr
+				}
+
+				// Leave objects interrupted by a cancellation out of the
+				// checkpoint so that they're picked up on resume
+				if err != nil && ctx.Err() != nil {
+					continue
+				}
+
+				if err != nil {
+					logging.Error("backfill failed: object_name=%q generation=%d err=%w", j.object.Name, j.object.Generation, err)
+				}
+
+				var outcome pipeline.Outcome
+				if result != nil {
+					outcome = result.Outcome
+				}
+
+				tracker.finish(j, outcome, err != nil)
+			}
+		}()
+	}
+
+	logging.Info("starting backfill: bucket_name=%q prefix=%q offset=%q", opts.BucketName, opts.Prefix, checkpoint.Offset)
+	listErr := dispatch(ctx, objects, opts, checkpoint.Offset, tracker, jobs)
+	close(jobs)
+	wg.Wait()
+
+	if err := tracker.save(); err != nil {
+		listErr = errors.Join(listErr, fmt.Errorf("could not save checkpoint: %w", err))
+	}
+
+	logging.Info(
+		"backfill stopped: bucket_name=%q prefix=%q offset=%q scanned=%d failed=%d",
+		opts.BucketName, opts.Prefix, checkpoint.Offset, checkpoint.Scanned, len(checkpoint.Failed),
+	)
+
+	return checkpoint, listErr
+}
+
+func dispatch(ctx context.Context, objects store.Lister, opts Options, offset string, tracker *progress, jobs chan<- job) error {
+	seq := 0
+
+	err := objects.List(ctx, opts.BucketName, opts.Prefix, offset, func(attrs *store.ObjectAttrs) error {
+		// The start offset is inclusive and the offset was already processed
+		if attrs.Name == offset {
+			return nil
+		}
+
+		// The pipeline reads the object from attrs.Bucket so don't rely on
+		// the listing to include it
+		attrs.Bucket = opts.BucketName
+		j := job{seq: seq, object: attrs}
+		tracker.add(j)
+
+		select {
+		case jobs <- j:
+			seq++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("objects.List: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leaktk/gcs-filter/pipeline"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+const testBucketName = "uploads"
+
+// bucketlessLister drops the bucket from the listing like a GCS listing
+// without it in the attribute selection
+type bucketlessLister struct {
+	*store.Memory
+}
+
+func (l bucketlessLister) List(ctx context.Context, bucketName, prefix, startOffset string, fn func(*store.ObjectAttrs) error) error {
+	return l.Memory.List(ctx, bucketName, prefix, startOffset, func(attrs *store.ObjectAttrs) error {
+		attrs.Bucket = ""
+		return fn(attrs)
+	})
+}
+
+// recorder is a ProcessFunc that keeps the objects it's passed and fails
+// the ones named in fail
+type recorder struct {
+	mu      sync.Mutex
+	objects []*store.ObjectAttrs
+	fail    map[string]bool
+}
+
+func (r *recorder) process(_ context.Context, object *store.ObjectAttrs) (*pipeline.ScanResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.objects = append(r.objects, object)
+
+	if r.fail[object.Name] {
+		return nil, errors.New("process failed")
+	}
+
+	return &pipeline.ScanResult{Outcome: pipeline.OutcomeClean}, nil
+}
+
+func (r *recorder) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.objects))
+	for _, object := range r.objects {
+		names = append(names, object.Name)
+	}
+
+	return names
+}
+
+// newTestStore provides a memory store with an object for each name
+func newTestStore(names ...string) *store.Memory {
+	objects := store.NewMemory()
+	for _, name := range names {
+		objects.Put(testBucketName, name, nil, []byte(name))
+	}
+
+	return objects
+}
+
+func TestRunSetsTheBucket(t *testing.T) {
+	objects := store.NewMemory()
+	objects.Put(testBucketName, "a.txt", nil, []byte("a"))
+
+	var r recorder
+	_, err := Run(context.Background(), bucketlessLister{objects}, Options{BucketName: testBucketName, Workers: 1}, r.process)
+	require.NoError(t, err)
+
+	require.Len(t, r.objects, 1)
+	assert.Equal(t, testBucketName, r.objects[0].Bucket)
+	assert.Equal(t, "a.txt", r.objects[0].Name)
+}
+
+func TestRunResumesFromTheCheckpoint(t *testing.T) {
+	objects := newTestStore("a.txt", "b.txt", "c.txt", "d.txt")
+	opts := Options{
+		BucketName:     testBucketName,
+		Workers:        1,
+		CheckpointPath: filepath.Join(t.TempDir(), "checkpoint.json"),
+	}
+
+	checkpoint := &Checkpoint{BucketName: testBucketName, Offset: "b.txt", Scanned: 2}
+	require.NoError(t, checkpoint.save(opts.CheckpointPath))
+
+	var r recorder
+	checkpoint, err := Run(context.Background(), objects, opts, r.process)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c.txt", "d.txt"}, r.names())
+	assert.Equal(t, "d.txt", checkpoint.Offset)
+	assert.Equal(t, 4, checkpoint.Scanned)
+
+	saved, err := loadCheckpoint(opts.CheckpointPath, testBucketName, "")
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint, saved)
+
+	// A checkpoint for another backfill isn't used
+	_, err = Run(context.Background(), objects, Options{BucketName: "other", Workers: 1, CheckpointPath: opts.CheckpointPath}, r.process)
+	assert.ErrorContains(t, err, "checkpoint is for a different backfill")
+}
+
+func TestProgressAdvancesInOrder(t *testing.T) {
+	tracker := &progress{
+		checkpoint: &Checkpoint{Outcomes: make(map[pipeline.Outcome]int)},
+		names:      make(map[int]string),
+		done:       make(map[int]bool),
+		failedSeq:  -1,
+	}
+
+	jobs := make([]job, 4)
+	for i, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		jobs[i] = job{seq: i, object: &store.ObjectAttrs{Name: name}}
+		tracker.add(jobs[i])
+	}
+
+	// The offset doesn't move until the earlier objects are done
+	tracker.finish(jobs[2], pipeline.OutcomeClean, false)
+	tracker.finish(jobs[1], pipeline.OutcomeClean, false)
+	assert.Empty(t, tracker.checkpoint.Offset)
+
+	tracker.finish(jobs[0], pipeline.OutcomeClean, false)
+	assert.Equal(t, "c.txt", tracker.checkpoint.Offset)
+
+	tracker.finish(jobs[3], pipeline.OutcomeClean, false)
+	assert.Equal(t, "d.txt", tracker.checkpoint.Offset)
+	assert.Empty(t, tracker.names)
+	assert.Empty(t, tracker.done)
+}
+
+func TestProgressStopsAtFailures(t *testing.T) {
+	tracker := &progress{
+		checkpoint: &Checkpoint{Outcomes: make(map[pipeline.Outcome]int)},
+		names:      make(map[int]string),
+		done:       make(map[int]bool),
+		failedSeq:  -1,
+	}
+
+	jobs := make([]job, 4)
+	for i, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		jobs[i] = job{seq: i, object: &store.ObjectAttrs{Name: name}}
+		tracker.add(jobs[i])
+	}
+
+	tracker.finish(jobs[3], pipeline.OutcomeClean, false)
+	tracker.finish(jobs[2], "", true)
+	tracker.finish(jobs[0], pipeline.OutcomeClean, false)
+	tracker.finish(jobs[1], pipeline.OutcomeClean, false)
+	assert.Equal(t, "b.txt", tracker.checkpoint.Offset)
+	assert.Equal(t, []string{"c.txt"}, tracker.checkpoint.Failed)
+	assert.Equal(t, 4, tracker.checkpoint.Scanned)
+}
+
+func TestRunRetriesFailedObjectsOnResume(t *testing.T) {
+	objects := newTestStore("a.txt", "b.txt", "c.txt", "d.txt")
+	opts := Options{
+		BucketName:     testBucketName,
+		Workers:        2,
+		CheckpointPath: filepath.Join(t.TempDir(), "checkpoint.json"),
+	}
+
+	r := recorder{fail: map[string]bool{"b.txt": true}}
+	checkpoint, err := Run(context.Background(), objects, opts, r.process)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.txt", "b.txt", "c.txt", "d.txt"}, r.names())
+	assert.Equal(t, "a.txt", checkpoint.Offset)
+	assert.Equal(t, []string{"b.txt"}, checkpoint.Failed)
+
+	// The failed object and the ones after it are scanned again
+	r = recorder{}
+	checkpoint, err = Run(context.Background(), objects, opts, r.process)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b.txt", "c.txt", "d.txt"}, r.names())
+	assert.Equal(t, "d.txt", checkpoint.Offset)
+	assert.Empty(t, checkpoint.Failed)
+}
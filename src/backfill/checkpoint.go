@@ -0,0 +1,98 @@
+package backfill
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/leaktk/gcs-filter/pipeline"
+)
+
+// Checkpoint records how far a backfill has gotten so that it can be resumed
+type Checkpoint struct {
+	BucketName string `json:"bucket_name"`
+	Prefix     string `json:"prefix"`
+	// Offset is the name of the last object where it and every object listed
+	// before it has been processed. It stops before the first object that
+	// failed so that resuming retries it.
+	Offset   string                   `json:"offset"`
+	Scanned  int                      `json:"scanned"`
+	Outcomes map[pipeline.Outcome]int `json:"outcomes"`
+	// Failed lists the objects that failed in the last run
+	Failed []string `json:"failed"`
+}
+
+// loadCheckpoint reads a checkpoint from path. A missing file returns an
+// empty checkpoint for the bucket and prefix.
+func loadCheckpoint(path, bucketName, prefix string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{
+		BucketName: bucketName,
+		Prefix:     prefix,
+		Outcomes:   make(map[pipeline.Outcome]int),
+	}
+
+	if path == "" {
+		return checkpoint, nil
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304 -- the path is provided by the operator
+	if errors.Is(err, fs.ErrNotExist) {
+		return checkpoint, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	if checkpoint.Outcomes == nil {
+		checkpoint.Outcomes = make(map[pipeline.Outcome]int)
+	}
+
+	if checkpoint.BucketName != bucketName || checkpoint.Prefix != prefix {
+		return nil, fmt.Errorf(
+			"checkpoint is for a different backfill: bucket_name=%q prefix=%q",
+			checkpoint.BucketName, checkpoint.Prefix,
+		)
+	}
+
+	return checkpoint, nil
+}
+
+// save atomically writes the checkpoint to path
+func (c *Checkpoint) save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %w", err)
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("tmp.Write: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("tmp.Close: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/leaktk/gcs-filter/backfill"
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/pipeline"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// backfillCommand runs every existing object in a bucket through the same
+// pipeline the cloud function uses, including reporting and redaction
+func backfillCommand(args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "only scan objects with names starting with this prefix")
+	workers := flags.Int("workers", 8, "how many objects to scan at once")
+	checkpointPath := flags.String("checkpoint", "", "file to save progress to and resume from")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gcs-filter backfill [-prefix P] [-workers N] [-checkpoint FILE] <bucket>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitError
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		logging.Error("config.NewConfig: %w", err)
+		return exitError
+	}
+
+	// Stop handing out objects on an interrupt so the checkpoint is saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg.Patterns.Start(ctx)
+	defer cfg.Patterns.Stop()
+
+	objectPipeline, err := pipeline.NewPipeline(ctx, cfg)
+	if err != nil {
+		logging.Error("pipeline.NewPipeline: %w", err)
+		return exitError
+	}
+
+	defer func() {
+		_ = objectPipeline.Close()
+	}()
+
+	opts := backfill.Options{
+		BucketName:     flags.Arg(0),
+		Prefix:         *prefix,
+		Workers:        *workers,
+		CheckpointPath: *checkpointPath,
+	}
+
+	objects, ok := objectPipeline.Store().(store.Lister)
+	if !ok {
+		logging.Error("the object store does not support listing objects")
+		return exitError
+	}
+
+	checkpoint, err := backfill.Run(ctx, objects, opts, objectPipeline.ProcessObject)
+	if err != nil {
+		logging.Error("backfill.Run: %w", err)
+		return exitError
+	}
+
+	if len(checkpoint.Failed) > 0 {
+		return exitError
+	}
+
+	return exitOK
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/leaktk/gcs-filter/config"
+)
+
+// configCommand runs the config subcommands
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "usage: gcs-filter config validate [-file PATH]")
+		return exitError
+	}
+
+	return configValidateCommand(args[1:])
+}
+
+// configValidateCommand loads the config the same way the cloud function does
+// and prints every problem found instead of stopping at the first one. If the
+// config is valid, it's printed with the secrets redacted.
+func configValidateCommand(args []string) int {
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	path := flags.String("file", "", "config file to validate (default: LEAKTK_GCS_FILTER_CONFIG_FILE)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gcs-filter config validate [-file PATH]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	var cfg *config.Config
+	var err error
+	if len(*path) > 0 {
+		cfg, err = config.NewConfigFromFile(*path)
+	} else {
+		cfg, err = config.NewConfig()
+	}
+
+	if err != nil {
+		problems := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			problems = joined.Unwrap()
+		}
+
+		// NewConfig joins every problem it finds together
+		fmt.Fprintln(os.Stderr, "config is invalid:")
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+		}
+
+		return exitError
+	}
+
+	fmt.Fprintln(os.Stderr, "config is valid")
+	fmt.Fprintln(os.Stdout, cfg.Redacted())
+	return exitOK
+}
@@ -0,0 +1,46 @@
+// Command gcs-filter runs the gcs-filter pipeline outside of Cloud Functions
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: gcs-filter <command> [arguments]
+
+commands:
+  scan <path|gs://bucket/object>  scan a local file or an object and print the leaks as JSON
+  backfill <bucket>               scan, report and redact every existing object in a bucket
+  restore <gs://bucket/name>      put a quarantined object back after a false-positive review
+  config validate [-file PATH]    check the config file and env vars and print every problem
+`
+
+// Exit codes shared by the commands
+const (
+	exitOK       = 0
+	exitRedacted = 1
+	exitError    = 2
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitError)
+	}
+
+	switch os.Args[1] {
+	case "scan":
+		os.Exit(scanCommand(os.Args[2:]))
+	case "backfill":
+		os.Exit(backfillCommand(os.Args[2:]))
+	case "restore":
+		os.Exit(restoreCommand(os.Args[2:]))
+	case "config":
+		os.Exit(configCommand(os.Args[2:]))
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %q\n\n%s", os.Args[1], usage)
+		os.Exit(exitError)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/redactor"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// restoreCommand puts a quarantined object back where it was quarantined
+// from after its leaks were reviewed and found to be false positives
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gcs-filter restore <quarantine object name|gs://quarantine-bucket/object>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitError
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		logging.Error("config.NewConfig: %w", err)
+		return exitError
+	}
+
+	quarantineObjectName := flags.Arg(0)
+	if strings.HasPrefix(quarantineObjectName, "gs://") {
+		bucketName, objectName, found := strings.Cut(strings.TrimPrefix(quarantineObjectName, "gs://"), "/")
+		if !found || bucketName == "" || objectName == "" {
+			logging.Error("invalid object URL: %q", quarantineObjectName)
+			return exitError
+		}
+
+		// The function only trusts the restored flag for objects in its
+		// own quarantine bucket
+		if bucketName != cfg.Redactor.QuarantineBucketName {
+			logging.Error("the object must be in LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME (%q) or the restored object would be redacted again: bucket_name=%q", cfg.Redactor.QuarantineBucketName, bucketName)
+			return exitError
+		}
+
+		quarantineObjectName = objectName
+	}
+
+	ctx := context.Background()
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		logging.Error("storage.NewClient: %w", err)
+		return exitError
+	}
+
+	defer func() {
+		_ = storageClient.Close()
+	}()
+
+	// The restored object is flagged so that the finalize event for it
+	// isn't redacted again
+	objects := store.NewGCS(storageClient)
+	if _, err := redactor.NewRedactor(cfg.Redactor, objects).Restore(ctx, quarantineObjectName); err != nil {
+		logging.Error("restore failed: %w", err)
+		return exitError
+	}
+
+	return exitOK
+}
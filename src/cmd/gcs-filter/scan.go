@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/pipeline"
+	"github.com/leaktk/gcs-filter/scanner"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// scanCommand scans a single local file or object with the same detector
+// and redaction decision used by the cloud function. It prints the leaks as
+// JSON and exits with exitRedacted if the object would have been redacted.
+func scanCommand(args []string) int {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	generation := flags.Int64("generation", 0, "object generation to scan (gs:// URLs only, default: latest)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gcs-filter scan [-generation N] <path|gs://bucket/object>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitError
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		logging.Error("config.NewConfig: %w", err)
+		return exitError
+	}
+
+	ctx := context.Background()
+	target := flags.Arg(0)
+	cfg.Patterns.Start(ctx)
+	defer cfg.Patterns.Stop()
+
+	var result *pipeline.ScanResult
+	if strings.HasPrefix(target, "gs://") {
+		result, err = scanObject(ctx, cfg, target, *generation)
+	} else {
+		result, err = scanFile(ctx, cfg, target)
+	}
+
+	if err != nil {
+		logging.Error("scan failed: %w", err)
+		return exitError
+	}
+
+	leaks := result.Leaks
+	if leaks == nil {
+		leaks = []*scanner.Leak{}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(leaks); err != nil {
+		logging.Error("json.Encode: %w", err)
+		return exitError
+	}
+
+	if result.WouldRedact(cfg.Redactor) {
+		return exitRedacted
+	}
+
+	return exitOK
+}
+
+func scanObject(ctx context.Context, cfg *config.Config, objectURL string, generation int64) (*pipeline.ScanResult, error) {
+	bucketName, objectName, found := strings.Cut(strings.TrimPrefix(objectURL, "gs://"), "/")
+	if !found || bucketName == "" || objectName == "" {
+		return nil, fmt.Errorf("invalid object URL: %q", objectURL)
+	}
+
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+
+	defer func() {
+		_ = storageClient.Close()
+	}()
+
+	objects := store.NewGCS(storageClient)
+	attrs, err := objects.Attrs(ctx, bucketName, objectName, generation)
+	if err != nil {
+		return nil, fmt.Errorf("objects.Attrs: %w", err)
+	}
+
+	result := pipeline.Scan(ctx, cfg, objects, attrs)
+	switch result.Outcome {
+	case pipeline.OutcomeSuperseded:
+		return nil, fmt.Errorf("object does not exist: %q generation=%d", objectURL, attrs.Generation)
+	case pipeline.OutcomeNotScanned:
+		return nil, fmt.Errorf("object not scanned: %s", result.NotScannedReason)
+	}
+
+	return result, result.ScanErr
+}
+
+func scanFile(ctx context.Context, cfg *config.Config, path string) (*pipeline.ScanResult, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Abs: %w", err)
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, errors.New("directories are not supported: " + path)
+	}
+
+	file, err := os.Open(absPath) // #nosec G304 -- scanning user provided paths is the point of the command
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	result := pipeline.ScanReader(ctx, cfg, "file://"+filepath.ToSlash(absPath), filepath.ToSlash(path), file)
+	return result, result.ScanErr
+}
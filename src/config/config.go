@@ -1,12 +1,18 @@
 package config
 
 import (
+	"context"
 	// Used to pull in embedded files when dist is built
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	gitleaksconfig "github.com/zricethezav/gitleaks/v8/config"
@@ -14,105 +20,367 @@ import (
 
 // Splunk contains the config for using the Splunk reporter to log leaks
 type Splunk struct {
-	Collector  string
-	Host       string
-	Index      string
-	Source     string
-	Sourcetype string
-	Token      string
+	Collector  string `toml:"collector" yaml:"collector"`
+	Host       string `toml:"host" yaml:"host"`
+	Index      string `toml:"index" yaml:"index"`
+	Source     string `toml:"source" yaml:"source"`
+	Sourcetype string `toml:"sourcetype" yaml:"sourcetype"`
+	Token      string `toml:"token" yaml:"token"`
+	// BatchSize is the most events sent in one request (default: 32)
+	BatchSize int `toml:"batch_size" yaml:"batch_size"`
+	// MaxBatchBytes is the largest request body to send (default: 1MiB). A
+	// single event larger than it is still sent on its own.
+	MaxBatchBytes int `toml:"max_batch_bytes" yaml:"max_batch_bytes"`
+	// RequestTimeout limits each request (default: 10s)
+	RequestTimeout string `toml:"request_timeout" yaml:"request_timeout"`
+	// RetryTimeout is how long a batch is retried for after 429s, 5xx
+	// responses and network errors (default: 60s). 0s disables retries.
+	RetryTimeout string `toml:"retry_timeout" yaml:"retry_timeout"`
+	// Ack waits for the indexers to acknowledge each batch and treats
+	// batches that aren't acknowledged as undelivered. The HEC token must
+	// have indexer acknowledgement enabled.
+	Ack bool `toml:"ack" yaml:"ack"`
+	// Channel is the HEC channel ID (a GUID) sent with each request. A
+	// random one is used if it isn't set.
+	Channel string `toml:"channel" yaml:"channel"`
+	// AckTimeout is how long to wait for the acknowledgements (default: 60s)
+	AckTimeout string `toml:"ack_timeout" yaml:"ack_timeout"`
+	// AckPollInterval is how often the acknowledgements are checked
+	// (default: 1s)
+	AckPollInterval string `toml:"ack_poll_interval" yaml:"ack_poll_interval"`
+	// CAFile is a PEM bundle of CAs to trust in addition to the system ones
+	CAFile string `toml:"ca_file" yaml:"ca_file"`
+	// CertFile and KeyFile are a PEM client certificate and key to present
+	// to the collector. They must be set together.
+	CertFile string `toml:"cert_file" yaml:"cert_file"`
+	KeyFile  string `toml:"key_file" yaml:"key_file"`
+	// InsecureSkipVerify disables verifying the collector's certificate. It's
+	// only meant for testing and a warning is logged when it's set.
+	InsecureSkipVerify bool `toml:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	// ProxyURL is the proxy requests are sent through. The HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY env vars are used if it isn't set.
+	ProxyURL string `toml:"proxy_url" yaml:"proxy_url"`
+	// Fields maps the details in SplunkFields to the names of the index-time
+	// fields they're sent as. Only the mapped details are sent. If it's empty,
+	// DefaultSplunkFields are sent under their own names.
+	Fields map[string]string `toml:"fields" yaml:"fields"`
 }
 
 // BigQuery contains the config for using the BigQueryReporter to log leaks
 type BigQuery struct {
-	ProjectID string
-	DatasetID string
-	TableID   string
+	ProjectID string `toml:"project_id" yaml:"project_id"`
+	DatasetID string `toml:"dataset_id" yaml:"dataset_id"`
+	TableID   string `toml:"table_id" yaml:"table_id"`
 }
 
-// Reporter contains the top level reporter config to pass to the various
-// NewReporter functions to set up that reporter
+// Reporter contains the top level reporter config to pass to reporter.NewRouter
+// to set up the reporters
 type Reporter struct {
-	Kinds    []string
-	Splunk   *Splunk
-	BigQuery *BigQuery
+	Kinds []string `toml:"kinds" yaml:"kinds"`
+	// RetryFailures fails the event when leaks can't be reported so that
+	// it's redelivered. Events for objects that were redacted aren't failed
+	// since the redelivered event would find the generation superseded.
+	RetryFailures bool      `toml:"retry_failures" yaml:"retry_failures"`
+	Splunk        *Splunk   `toml:"splunk" yaml:"splunk"`
+	BigQuery      *BigQuery `toml:"bigquery" yaml:"bigquery"`
 }
 
+// Redactor modes control how the content of an object is redacted
+const (
+	// RedactorModeNotice replaces the full object with a notice
+	RedactorModeNotice = "notice"
+	// RedactorModeMask replaces only the offending secrets in the object
+	RedactorModeMask = "mask"
+	// RedactorModeDelete deletes the object
+	RedactorModeDelete = "delete"
+	// RedactorModeMove quarantines the object and then deletes it
+	RedactorModeMove = "move"
+)
+
+// RedactorModes lists the supported redactor modes
+var RedactorModes = []string{RedactorModeNotice, RedactorModeMask, RedactorModeDelete, RedactorModeMove}
+
+// kmsKeyPattern matches Cloud KMS crypto key names
+var kmsKeyPattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
 // Redactor contains config and feature flags around redacting content
 type Redactor struct {
-	Enabled              bool
-	Quarantine           bool
-	QuarantineBucketName string
+	Enabled              bool   `toml:"enabled" yaml:"enabled"`
+	Mode                 string `toml:"mode" yaml:"mode"`
+	Quarantine           bool   `toml:"quarantine" yaml:"quarantine"`
+	QuarantineBucketName string `toml:"quarantine_bucket_name" yaml:"quarantine_bucket_name"`
+	// Notice is a text/template for the notice objects are replaced with in
+	// notice mode. See NoticeData for what it can reference.
+	Notice string `toml:"notice" yaml:"notice"`
+	// ContactURL is passed to the notice template so that people know who
+	// to reach out to about a redacted object
+	ContactURL string `toml:"contact_url" yaml:"contact_url"`
+	// QuarantineRetention is how long quarantined objects should be kept
+	// (e.g. "720h"). It's recorded on the quarantined objects for a bucket
+	// lifecycle rule or review process to act on.
+	QuarantineRetention string `toml:"quarantine_retention" yaml:"quarantine_retention"`
+	// QuarantineKMSKey is the Cloud KMS key that wraps the data keys used
+	// to encrypt quarantined objects
+	QuarantineKMSKey string `toml:"quarantine_kms_key" yaml:"quarantine_kms_key"`
+	// QuarantineKeyFile is a file with a base64 encoded 256 bit key to use
+	// in place of QuarantineKMSKey for tests and local runs
+	QuarantineKeyFile string `toml:"quarantine_key_file" yaml:"quarantine_key_file"`
+	// Eligibility decides which leaks are in scope for redaction. The
+	// first policy a leak matches is used. DefaultEligibilityPolicies are
+	// used if it's empty.
+	Eligibility []*EligibilityPolicy `toml:"eligibility" yaml:"eligibility"`
+	// MinLeakCount is the fewest eligible leaks an object needs to be
+	// redacted. Objects with fewer are only reported.
+	MinLeakCount int `toml:"min_leak_count" yaml:"min_leak_count"`
+	// MinEntropy is the lowest offender entropy an eligible leak can have
+	MinEntropy float64 `toml:"min_entropy" yaml:"min_entropy"`
+	// Severities maps rule tags to severities. A leak gets the highest
+	// severity of its tags or SeverityLow if none of them are mapped.
+	Severities map[string]string `toml:"severities" yaml:"severities"`
+	// MinSeverity is the lowest severity an eligible leak can have. If it's
+	// empty, severities aren't checked.
+	MinSeverity string `toml:"min_severity" yaml:"min_severity"`
+}
+
+// ScanErrors controls what happens when an object can't be scanned
+type ScanErrors struct {
+	// Retry returns an error for transient failures so the event is
+	// redelivered
+	Retry bool `toml:"retry" yaml:"retry"`
+	// DeadLetter reports permanent failures through the reporter
+	DeadLetter bool `toml:"dead_letter" yaml:"dead_letter"`
+	// Quarantine copies objects that permanently fail to scan to the
+	// quarantine bucket
+	Quarantine bool `toml:"quarantine" yaml:"quarantine"`
+}
+
+// Gates contains the rules for skipping objects based on their metadata
+// before they're scanned
+type Gates struct {
+	// MaxObjectSize is the largest object in bytes that will be scanned. 0
+	// disables the limit.
+	MaxObjectSize int64 `toml:"max_object_size" yaml:"max_object_size"`
+	// ContentTypeAllow limits scanning to these content types if set.
+	// Patterns like "text/*" match every subtype.
+	ContentTypeAllow []string `toml:"content_type_allow" yaml:"content_type_allow"`
+	// ContentTypeDeny skips these content types
+	ContentTypeDeny []string `toml:"content_type_deny" yaml:"content_type_deny"`
+	// ExtensionDeny skips object names ending in these extensions
+	ExtensionDeny []string `toml:"extension_deny" yaml:"extension_deny"`
+}
+
+// Dedup contains the config for skipping events and object generations that
+// were already processed
+type Dedup struct {
+	// Kind is the store to use: "Memory", "GCS" or empty to disable it
+	Kind       string `toml:"kind" yaml:"kind"`
+	MemorySize int    `toml:"memory_size" yaml:"memory_size"`
+	BucketName string `toml:"bucket_name" yaml:"bucket_name"`
+	Prefix     string `toml:"prefix" yaml:"prefix"`
+}
+
+// PatternServer contains the config for fetching the gitleaks patterns at
+// runtime
+type PatternServer struct {
+	Autofetch bool   `toml:"autofetch" yaml:"autofetch"`
+	URL       string `toml:"url" yaml:"url"`
+	AuthToken string `toml:"auth_token" yaml:"auth_token"`
+	// RefreshInterval is a duration like "12h". "0" disables the refresh.
+	RefreshInterval string `toml:"refresh_interval" yaml:"refresh_interval"`
+	CachePath       string `toml:"cache_path" yaml:"cache_path"`
 }
 
 // Config contains all of the config for the app
 type Config struct {
-	Gitleaks *gitleaksconfig.Config
-	Redactor *Redactor
-	Reporter *Reporter
+	Patterns      *Patterns      `json:"-" toml:"-" yaml:"-"`
+	PatternServer *PatternServer `toml:"pattern_server" yaml:"pattern_server"`
+	Dedup         *Dedup         `toml:"dedup" yaml:"dedup"`
+	Gates         *Gates         `toml:"gates" yaml:"gates"`
+	Redactor      *Redactor      `toml:"redactor" yaml:"redactor"`
+	Reporter      *Reporter      `toml:"reporter" yaml:"reporter"`
+	ScanErrors    *ScanErrors    `toml:"scan_errors" yaml:"scan_errors"`
+	// Policies are checked in order and the first match is used
+	Policies []*Policy `toml:"policies" yaml:"policies"`
+
+	// secretKeys are the config keys whose values were resolved from secret
+	// references so that Redacted can hide them
+	secretKeys map[string]bool
 }
 
 //go:embed gitleaks.toml
 var rawGitleaks string
 
-func newRedactorConfig() (*Redactor, error) {
-	r := &Redactor{
-		Enabled:              os.Getenv("LEAKTK_GCS_FILTER_REDACTOR_ENABLED") != "false",
-		Quarantine:           os.Getenv("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE") == "true",
-		QuarantineBucketName: os.Getenv("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME"),
+// defaultConfig returns the config used for any settings that aren't set in
+// the config file or env vars
+func defaultConfig() *Config {
+	return &Config{
+		PatternServer: &PatternServer{
+			RefreshInterval: "12h",
+		},
+		Dedup: &Dedup{
+			MemorySize: 10000,
+			Prefix:     "leaktk-gcs-filter/processed/",
+		},
+		Gates: &Gates{},
+		Redactor: &Redactor{
+			Enabled:      true,
+			Mode:         RedactorModeNotice,
+			MinLeakCount: 1,
+		},
+		Reporter:   &Reporter{},
+		ScanErrors: &ScanErrors{},
+	}
+}
+
+func (r *Redactor) validate() []error {
+	var errs []error
+
+	if !slices.Contains(RedactorModes, r.Mode) {
+		errs = append(errs, fmt.Errorf("LEAKTK_GCS_FILTER_REDACTOR_MODE must be one of: %s", strings.Join(RedactorModes, ", ")))
+	}
+
+	if r.Mode == RedactorModeMove && len(r.QuarantineBucketName) == 0 {
+		errs = append(errs, fmt.Errorf("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME must be set if LEAKTK_GCS_FILTER_REDACTOR_MODE is %q", RedactorModeMove))
 	}
 
+	errs = append(errs, r.validateNotice()...)
+
 	if r.Quarantine {
 		if !r.Enabled {
-			return nil, errors.New("LEAKTK_GCS_FILTER_REDACTOR_ENABLED must be set to true if LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE is true")
+			errs = append(errs, errors.New("LEAKTK_GCS_FILTER_REDACTOR_ENABLED must be set to true if LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE is true"))
 		}
 
 		if len(r.QuarantineBucketName) == 0 {
-			return nil, errors.New("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME must be set if LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE is true")
+			errs = append(errs, errors.New("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME must be set if LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE is true"))
+		}
+	}
+
+	if len(r.QuarantineRetention) > 0 {
+		if retention, err := time.ParseDuration(r.QuarantineRetention); err != nil || retention < 0 {
+			errs = append(errs, errors.New("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_RETENTION must be a non-negative duration (e.g. 720h)"))
+		}
+	}
+
+	if len(r.QuarantineKMSKey) > 0 && len(r.QuarantineKeyFile) > 0 {
+		errs = append(errs, errors.New("only one of LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_KMS_KEY and LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_KEY_FILE can be set"))
+	}
+
+	if len(r.QuarantineKMSKey) > 0 && !kmsKeyPattern.MatchString(r.QuarantineKMSKey) {
+		errs = append(errs, errors.New("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_KMS_KEY must look like projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY"))
+	}
+
+	if r.MinLeakCount < 1 {
+		errs = append(errs, errors.New("LEAKTK_GCS_FILTER_REDACTOR_MIN_LEAK_COUNT must be a positive integer"))
+	}
+
+	if r.MinEntropy < 0 {
+		errs = append(errs, errors.New("LEAKTK_GCS_FILTER_REDACTOR_MIN_ENTROPY can't be negative"))
+	}
+
+	if len(r.MinSeverity) > 0 && SeverityRank(r.MinSeverity) == 0 {
+		errs = append(errs, fmt.Errorf("LEAKTK_GCS_FILTER_REDACTOR_MIN_SEVERITY must be one of: %s", strings.Join(Severities, ", ")))
+	}
+
+	for tag, severity := range r.Severities {
+		if SeverityRank(severity) == 0 {
+			errs = append(errs, fmt.Errorf("redactor severity for tag %q must be one of: %s", tag, strings.Join(Severities, ", ")))
+		}
+	}
+
+	return append(errs, validateEligibility(r.Eligibility)...)
+}
+
+func (s *ScanErrors) validate(redactorConfig *Redactor) []error {
+	if s.Quarantine && len(redactorConfig.QuarantineBucketName) == 0 {
+		return []error{errors.New("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME must be set if LEAKTK_GCS_FILTER_SCAN_ERRORS_QUARANTINE is true")}
+	}
+
+	return nil
+}
+
+func (g *Gates) validate() []error {
+	if g.MaxObjectSize < 0 {
+		return []error{errors.New("LEAKTK_GCS_FILTER_GATES_MAX_OBJECT_SIZE must be a non-negative integer")}
+	}
+
+	return nil
+}
+
+func (d *Dedup) validate() []error {
+	var errs []error
+
+	if d.MemorySize < 1 {
+		errs = append(errs, errors.New("LEAKTK_GCS_FILTER_DEDUP_MEMORY_SIZE must be a positive integer"))
+	}
+
+	switch d.Kind {
+	case "", "Memory":
+	case "GCS":
+		if len(d.BucketName) == 0 {
+			errs = append(errs, errors.New("LEAKTK_GCS_FILTER_DEDUP_BUCKET_NAME must be set if LEAKTK_GCS_FILTER_DEDUP_KIND is GCS"))
 		}
+	default:
+		errs = append(errs, errors.New("LEAKTK_GCS_FILTER_DEDUP_KIND must be empty, Memory or GCS"))
 	}
 
-	return r, nil
+	return errs
 }
 
-func newReporterConfig() *Reporter {
-	r := &Reporter{
-		Kinds: strings.Split(strings.ReplaceAll(os.Getenv("LEAKTK_GCS_FILTER_REPORTER_KINDS"), " ", ""), ","),
+func (ps *PatternServer) validate() []error {
+	if !ps.Autofetch {
+		return nil
 	}
 
-	if len(r.Kinds) == 0 {
-		r.Kinds = []string{"Logger"}
+	var errs []error
+
+	if len(ps.URL) == 0 {
+		errs = append(errs, errors.New("LEAKTK_PATTERN_SERVER_URL must be set if LEAKTK_GCS_FILTER_PATTERN_SERVER_AUTOFETCH is true"))
 	}
 
-	for _, kind := range r.Kinds {
-		switch kind {
-		case "Splunk":
-			r.Splunk = &Splunk{
-				Collector:  os.Getenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_COLLECTOR"),
-				Host:       os.Getenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_HOST"),
-				Index:      os.Getenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_INDEX"),
-				Source:     os.Getenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_SOURCE"),
-				Sourcetype: os.Getenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_SOURCETYPE"),
-				Token:      os.Getenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_TOKEN"),
-			}
-		case "BigQuery":
-			r.BigQuery = &BigQuery{
-				ProjectID: os.Getenv("LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_PROJECT_ID"),
-				DatasetID: os.Getenv("LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_DATASET_ID"),
-				TableID:   os.Getenv("LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_TABLE_ID"),
-			}
+	if interval, err := time.ParseDuration(ps.RefreshInterval); err != nil || interval < 0 {
+		errs = append(errs, errors.New("LEAKTK_GCS_FILTER_PATTERN_REFRESH_INTERVAL must be a non-negative duration (e.g. 12h)"))
+	}
+
+	return errs
+}
+
+// validate checks the config and returns every problem found
+func (c *Config) validate() []error {
+	var errs []error
+
+	errs = append(errs, c.PatternServer.validate()...)
+	errs = append(errs, c.Redactor.validate()...)
+	errs = append(errs, c.ScanErrors.validate(c.Redactor)...)
+	errs = append(errs, c.Gates.validate()...)
+	errs = append(errs, c.Dedup.validate()...)
+	errs = append(errs, c.Reporter.validate(c.AllReporterKinds())...)
+	errs = append(errs, validatePolicies(c.Policies, c.Redactor)...)
+
+	return errs
+}
+
+// splitList splits a comma separated env var value dropping blank items
+func splitList(value string) []string {
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
 		}
 	}
 
-	return r
+	return items
 }
 
-func parseConfig(rawConfig string) (*gitleaksconfig.Config, error) {
+func parseConfig(rawConfig string) (gitleaksConfig *gitleaksconfig.Config, err error) {
 	var vc gitleaksconfig.ViperConfig
 	var cfg gitleaksconfig.Config
-	var err error
 
+	// Named results so that a panic while translating the config is returned
+	// as an error instead of a nil config
 	defer func() {
 		if r := recover(); r != nil {
+			gitleaksConfig = nil
 			err = fmt.Errorf("gitleaks config is invalid: %v", r)
 		}
 	}()
@@ -140,21 +408,108 @@ func parseConfig(rawConfig string) (*gitleaksconfig.Config, error) {
 	return &cfg, err
 }
 
-// NewConfig loads the config for the app from memory and env vars
-func NewConfig() (*Config, error) {
-	gitleaksConfig, err := parseConfig(rawGitleaks)
+// redactedValue replaces secrets in the config dump
+const redactedValue = "REDACTED"
+
+func redact(value string) string {
+	if len(value) == 0 {
+		return value
+	}
+
+	return redactedValue
+}
+
+// redactURL hides the userinfo in a URL since it can hold credentials
+func redactURL(value string) string {
+	u, err := url.Parse(value)
 	if err != nil {
-		return nil, err
+		return redact(value)
 	}
 
-	redactorConfig, err := newRedactorConfig()
+	if u.User == nil {
+		return value
+	}
+
+	u.User = url.User(redactedValue)
+	return u.String()
+}
+
+// Redacted returns the config as JSON with the secrets replaced so that it
+// can be logged. Along with the known secret fields, every value that was
+// resolved from a secret reference is replaced.
+func (c *Config) Redacted() string {
+	// Round trip through JSON for a deep copy so the config isn't changed
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("could not marshal config: %s", err)
+	}
+
+	var redacted Config
+	if err := json.Unmarshal(data, &redacted); err != nil {
+		return fmt.Sprintf("could not copy config: %s", err)
+	}
+
+	if redacted.PatternServer != nil {
+		redacted.PatternServer.AuthToken = redact(redacted.PatternServer.AuthToken)
+	}
+
+	if redacted.Reporter != nil && redacted.Reporter.Splunk != nil {
+		redacted.Reporter.Splunk.Token = redact(redacted.Reporter.Splunk.Token)
+		redacted.Reporter.Splunk.ProxyURL = redactURL(redacted.Reporter.Splunk.ProxyURL)
+	}
+
+	walkStrings("", reflect.ValueOf(&redacted), func(key string, value reflect.Value) {
+		if c.secretKeys[key] {
+			value.SetString(redact(value.String()))
+		}
+	})
+
+	data, err = json.Marshal(redacted)
+	if err != nil {
+		return fmt.Sprintf("could not marshal config: %s", err)
+	}
+
+	return string(data)
+}
+
+// NewConfig loads the config for the app from memory, the config file (if
+// there is one) and env vars
+func NewConfig() (*Config, error) {
+	return NewConfigFromFile(configFilePath())
+}
+
+// NewConfigFromFile loads the config for the app from memory, the provided
+// config file and env vars. Env vars override values in the file and an
+// empty path only loads env vars. Any value can be a secret reference (e.g.
+// file:///path or sm://projects/p/secrets/s) which is resolved before the
+// config is validated. Every problem found is returned joined together
+// instead of just the first one.
+func NewConfigFromFile(path string) (*Config, error) {
+	return newConfig(path, defaultSecretResolvers())
+}
+
+func newConfig(path string, resolvers map[string]SecretResolver) (*Config, error) {
+	cfg := defaultConfig()
+
+	if len(path) > 0 {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	errs := cfg.loadEnv()
+	// Resolve the references before validating so the values are checked
+	errs = append(errs, cfg.resolveSecrets(context.Background(), resolvers)...)
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	patterns, err := newPatterns(cfg.PatternServer)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Config{
-		Gitleaks: gitleaksConfig,
-		Redactor: redactorConfig,
-		Reporter: newReporterConfig(),
-	}, nil
+	cfg.Patterns = patterns
+	return cfg, nil
 }
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EligibilityPolicy decides which leaks put an object in scope for
+// redaction. A leak matches if it passes every check that is set.
+type EligibilityPolicy struct {
+	// Name is recorded on the leaks the policy matches for auditing
+	Name string `toml:"name" yaml:"name"`
+	// IncludeTags must all be on the leak's rule
+	IncludeTags []string `toml:"include_tags" yaml:"include_tags"`
+	// ExcludeTags must not be on the leak's rule
+	ExcludeTags []string `toml:"exclude_tags" yaml:"exclude_tags"`
+	// Rules limits the policy to these rule IDs if set
+	Rules []string `toml:"rules" yaml:"rules"`
+	// ExcludeRules are rule IDs the policy never matches
+	ExcludeRules []string `toml:"exclude_rules" yaml:"exclude_rules"`
+	// MinEntropy is the lowest offender entropy the policy matches
+	MinEntropy float64 `toml:"min_entropy" yaml:"min_entropy"`
+	// RuleMinEntropy sets the confidence needed for specific rule IDs by
+	// overriding MinEntropy for them
+	RuleMinEntropy map[string]float64 `toml:"rule_min_entropy" yaml:"rule_min_entropy"`
+}
+
+// Leak severities from lowest to highest
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// Severities lists the severities from lowest to highest
+var Severities = []string{SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// SeverityRank returns a number to compare severities with or 0 if the
+// severity isn't valid
+func SeverityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// Severity returns the highest severity mapped from the tags or SeverityLow
+// if none of them are mapped
+func (r *Redactor) Severity(tags []string) string {
+	severity := SeverityLow
+
+	for _, tag := range tags {
+		if mapped, ok := r.Severities[tag]; ok && SeverityRank(mapped) > SeverityRank(severity) {
+			severity = mapped
+		}
+	}
+
+	return severity
+}
+
+// DefaultEligibilityPolicies are used when none are configured. They match
+// production ready secret rules.
+var DefaultEligibilityPolicies = []*EligibilityPolicy{
+	{
+		Name:        "production-secrets",
+		IncludeTags: []string{"type:secret"},
+		ExcludeTags: []string{"group:leaktk-testing"},
+	},
+}
+
+// EligibilityPolicies returns the configured eligibility policies or the
+// defaults if there aren't any
+func (r *Redactor) EligibilityPolicies() []*EligibilityPolicy {
+	if len(r.Eligibility) == 0 {
+		return DefaultEligibilityPolicies
+	}
+
+	return r.Eligibility
+}
+
+func validateEligibility(policies []*EligibilityPolicy) []error {
+	var errs []error
+	names := make(map[string]bool, len(policies))
+
+	for i, policy := range policies {
+		if policy == nil {
+			errs = append(errs, errors.New("redactor eligibility policies can't be empty"))
+			continue
+		}
+
+		if len(policy.Name) == 0 {
+			errs = append(errs, fmt.Errorf("redactor.eligibility[%d] must have a name", i))
+		} else if names[policy.Name] {
+			errs = append(errs, fmt.Errorf("redactor eligibility policy %q is defined more than once", policy.Name))
+		}
+
+		names[policy.Name] = true
+
+		if policy.MinEntropy < 0 {
+			errs = append(errs, fmt.Errorf("redactor eligibility policy %q min_entropy can't be negative", policy.Name))
+		}
+
+		for rule, entropy := range policy.RuleMinEntropy {
+			if entropy < 0 {
+				errs = append(errs, fmt.Errorf("redactor eligibility policy %q rule_min_entropy for %q can't be negative", policy.Name, rule))
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The helpers below only change a value if its env var is set so that env
+// vars override the config file and the defaults
+
+func envString(name string, value *string) {
+	if rawValue := os.Getenv(name); len(rawValue) > 0 {
+		*value = rawValue
+	}
+}
+
+func envList(name string, value *[]string) {
+	if rawValue := os.Getenv(name); len(rawValue) > 0 {
+		*value = splitList(rawValue)
+	}
+}
+
+// envBool accepts the values strconv.ParseBool does along with yes/no and
+// on/off, which were accepted before the bool settings were validated
+func envBool(name string, value *bool) error {
+	rawValue := strings.TrimSpace(os.Getenv(name))
+	if len(rawValue) == 0 {
+		return nil
+	}
+
+	switch strings.ToLower(rawValue) {
+	case "yes", "y", "on":
+		*value = true
+		return nil
+	case "no", "n", "off":
+		*value = false
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(rawValue)
+	if err != nil {
+		return fmt.Errorf("%s must be true or false", name)
+	}
+
+	*value = parsed
+	return nil
+}
+
+func envInt(name string, value *int) error {
+	rawValue := os.Getenv(name)
+	if len(rawValue) == 0 {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(rawValue)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer", name)
+	}
+
+	*value = parsed
+	return nil
+}
+
+func envFloat(name string, value *float64) error {
+	rawValue := os.Getenv(name)
+	if len(rawValue) == 0 {
+		return nil
+	}
+
+	parsed, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return fmt.Errorf("%s must be a number", name)
+	}
+
+	*value = parsed
+	return nil
+}
+
+func envInt64(name string, value *int64) error {
+	rawValue := os.Getenv(name)
+	if len(rawValue) == 0 {
+		return nil
+	}
+
+	parsed, err := strconv.ParseInt(rawValue, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer", name)
+	}
+
+	*value = parsed
+	return nil
+}
+
+// collect drops the nil errors
+func collect(errs ...error) []error {
+	var collected []error
+
+	for _, err := range errs {
+		if err != nil {
+			collected = append(collected, err)
+		}
+	}
+
+	return collected
+}
+
+func (ps *PatternServer) loadEnv() []error {
+	envString("LEAKTK_PATTERN_SERVER_URL", &ps.URL)
+	envString("LEAKTK_PATTERN_SERVER_AUTH_TOKEN", &ps.AuthToken)
+	envString("LEAKTK_GCS_FILTER_PATTERN_REFRESH_INTERVAL", &ps.RefreshInterval)
+	envString("LEAKTK_GCS_FILTER_PATTERN_CACHE_PATH", &ps.CachePath)
+
+	return collect(
+		envBool("LEAKTK_GCS_FILTER_PATTERN_SERVER_AUTOFETCH", &ps.Autofetch),
+	)
+}
+
+func (r *Redactor) loadEnv() []error {
+	envString("LEAKTK_GCS_FILTER_REDACTOR_MODE", &r.Mode)
+	envString("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME", &r.QuarantineBucketName)
+	envString("LEAKTK_GCS_FILTER_REDACTOR_MIN_SEVERITY", &r.MinSeverity)
+	envString("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_RETENTION", &r.QuarantineRetention)
+	envString("LEAKTK_GCS_FILTER_REDACTOR_NOTICE", &r.Notice)
+	envString("LEAKTK_GCS_FILTER_REDACTOR_CONTACT_URL", &r.ContactURL)
+	envString("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_KMS_KEY", &r.QuarantineKMSKey)
+	envString("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_KEY_FILE", &r.QuarantineKeyFile)
+
+	return collect(
+		envBool("LEAKTK_GCS_FILTER_REDACTOR_ENABLED", &r.Enabled),
+		envBool("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE", &r.Quarantine),
+		envInt("LEAKTK_GCS_FILTER_REDACTOR_MIN_LEAK_COUNT", &r.MinLeakCount),
+		envFloat("LEAKTK_GCS_FILTER_REDACTOR_MIN_ENTROPY", &r.MinEntropy),
+	)
+}
+
+func (s *ScanErrors) loadEnv() []error {
+	return collect(
+		envBool("LEAKTK_GCS_FILTER_SCAN_ERRORS_RETRY", &s.Retry),
+		envBool("LEAKTK_GCS_FILTER_SCAN_ERRORS_DEAD_LETTER", &s.DeadLetter),
+		envBool("LEAKTK_GCS_FILTER_SCAN_ERRORS_QUARANTINE", &s.Quarantine),
+	)
+}
+
+func (g *Gates) loadEnv() []error {
+	envList("LEAKTK_GCS_FILTER_GATES_CONTENT_TYPE_ALLOW", &g.ContentTypeAllow)
+	envList("LEAKTK_GCS_FILTER_GATES_CONTENT_TYPE_DENY", &g.ContentTypeDeny)
+	envList("LEAKTK_GCS_FILTER_GATES_EXTENSION_DENY", &g.ExtensionDeny)
+
+	return collect(
+		envInt64("LEAKTK_GCS_FILTER_GATES_MAX_OBJECT_SIZE", &g.MaxObjectSize),
+	)
+}
+
+func (d *Dedup) loadEnv() []error {
+	envString("LEAKTK_GCS_FILTER_DEDUP_KIND", &d.Kind)
+	envString("LEAKTK_GCS_FILTER_DEDUP_BUCKET_NAME", &d.BucketName)
+	envString("LEAKTK_GCS_FILTER_DEDUP_PREFIX", &d.Prefix)
+
+	return collect(
+		envInt("LEAKTK_GCS_FILTER_DEDUP_MEMORY_SIZE", &d.MemorySize),
+	)
+}
+
+// loadEnv sets the reporter settings for the default kinds and the other
+// kinds used by policies and returns every value that couldn't be parsed
+func (r *Reporter) loadEnv(policies []*Policy) []error {
+	if rawKinds := os.Getenv("LEAKTK_GCS_FILTER_REPORTER_KINDS"); len(rawKinds) > 0 {
+		r.Kinds = splitList(rawKinds)
+	}
+
+	if len(r.Kinds) == 0 {
+		r.Kinds = []string{"Logger"}
+	}
+
+	errs := collect(
+		envBool("LEAKTK_GCS_FILTER_REPORTER_RETRY_FAILURES", &r.RetryFailures),
+	)
+	kinds := r.Kinds
+	for _, policy := range policies {
+		if policy != nil {
+			kinds = append(kinds[:len(kinds):len(kinds)], policy.ReporterKinds...)
+		}
+	}
+
+	for _, kind := range kinds {
+		switch kind {
+		case "Splunk":
+			if r.Splunk == nil {
+				r.Splunk = &Splunk{}
+			}
+		case "BigQuery":
+			if r.BigQuery == nil {
+				r.BigQuery = &BigQuery{}
+			}
+		}
+	}
+
+	if r.Splunk != nil {
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_COLLECTOR", &r.Splunk.Collector)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_HOST", &r.Splunk.Host)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_INDEX", &r.Splunk.Index)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_SOURCE", &r.Splunk.Source)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_SOURCETYPE", &r.Splunk.Sourcetype)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_TOKEN", &r.Splunk.Token)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_REQUEST_TIMEOUT", &r.Splunk.RequestTimeout)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_RETRY_TIMEOUT", &r.Splunk.RetryTimeout)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_CHANNEL", &r.Splunk.Channel)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_ACK_TIMEOUT", &r.Splunk.AckTimeout)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_ACK_POLL_INTERVAL", &r.Splunk.AckPollInterval)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_CA_FILE", &r.Splunk.CAFile)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_CERT_FILE", &r.Splunk.CertFile)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_KEY_FILE", &r.Splunk.KeyFile)
+		envString("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_PROXY_URL", &r.Splunk.ProxyURL)
+		errs = append(errs, collect(
+			envInt("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_BATCH_SIZE", &r.Splunk.BatchSize),
+			envInt("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_MAX_BATCH_BYTES", &r.Splunk.MaxBatchBytes),
+			envBool("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_ACK", &r.Splunk.Ack),
+			envBool("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_INSECURE_SKIP_VERIFY", &r.Splunk.InsecureSkipVerify),
+		)...)
+	}
+
+	if r.BigQuery != nil {
+		envString("LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_PROJECT_ID", &r.BigQuery.ProjectID)
+		envString("LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_DATASET_ID", &r.BigQuery.DatasetID)
+		envString("LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_TABLE_ID", &r.BigQuery.TableID)
+	}
+
+	return errs
+}
+
+// loadEnv overrides the config with any env vars that are set and returns
+// every value that couldn't be parsed
+func (c *Config) loadEnv() []error {
+	var errs []error
+
+	errs = append(errs, c.PatternServer.loadEnv()...)
+	errs = append(errs, c.Redactor.loadEnv()...)
+	errs = append(errs, c.ScanErrors.loadEnv()...)
+	errs = append(errs, c.Gates.loadEnv()...)
+	errs = append(errs, c.Dedup.loadEnv()...)
+	errs = append(errs, c.Reporter.loadEnv(c.Policies)...)
+
+	return errs
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFilePath is where the config file is mounted from Secret
+// Manager when LEAKTK_GCS_FILTER_CONFIG_SECRET is set during a deploy
+const defaultConfigFilePath = "/etc/leaktk-gcs-filter/config.toml"
+
+// configFilePath returns the path of the config file to load or an empty
+// string if there isn't one
+func configFilePath() string {
+	if path := os.Getenv("LEAKTK_GCS_FILTER_CONFIG_FILE"); len(path) > 0 {
+		return path
+	}
+
+	if _, err := os.Stat(defaultConfigFilePath); err == nil {
+		return defaultConfigFilePath
+	}
+
+	return ""
+}
+
+// loadFile sets the values from a TOML or YAML config file (based on the
+// extension) on top of the current config. Unknown settings are rejected so
+// that typos don't go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path) // #nosec G304 -- the path comes from the operator
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		// An empty file is a valid config file
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("invalid config file %q: %w", path, err)
+		}
+	default:
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("invalid config file %q: %w", path, err)
+		}
+
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			var errs []error
+			for _, key := range undecoded {
+				errs = append(errs, fmt.Errorf("unknown setting in config file %q: %s", path, key))
+			}
+
+			return errors.Join(errs...)
+		}
+	}
+
+	return nil
+}
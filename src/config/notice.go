@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+)
+
+// DefaultNoticeTemplate is the notice objects are replaced with in notice
+// mode if NoticeTemplate isn't set
+const DefaultNoticeTemplate = "This file contained potentially sensitive information and has been removed.\n"
+
+// NoticeData is what a notice template can reference
+type NoticeData struct {
+	BucketName string
+	ObjectName string
+	Generation int64
+	// LeakIDs are the IDs of the leaks that caused the object to be
+	// redacted so they can be looked up in the reports
+	LeakIDs    []string
+	ContactURL string
+}
+
+// NoticeTemplate returns the parsed notice template from the config or the
+// default one
+func (r *Redactor) NoticeTemplate() (*template.Template, error) {
+	text := r.Notice
+	if len(text) == 0 {
+		text = DefaultNoticeTemplate
+	}
+
+	return template.New("notice").Option("missingkey=error").Parse(text)
+}
+
+func (r *Redactor) validateNotice() []error {
+	tmpl, err := r.NoticeTemplate()
+	if err != nil {
+		return []error{fmt.Errorf("LEAKTK_GCS_FILTER_REDACTOR_NOTICE is not a valid template: %w", err)}
+	}
+
+	// Execute it once to catch fields that don't exist
+	sample := NoticeData{
+		BucketName: "bucket",
+		ObjectName: "object",
+		Generation: 1,
+		LeakIDs:    []string{"leak-id"},
+		ContactURL: r.ContactURL,
+	}
+
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return []error{fmt.Errorf("LEAKTK_GCS_FILTER_REDACTOR_NOTICE is not a valid template: %w", err)}
+	}
+
+	return nil
+}
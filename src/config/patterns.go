@@ -0,0 +1,244 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gitleaksconfig "github.com/zricethezav/gitleaks/v8/config"
+
+	"github.com/leaktk/gcs-filter/logging"
+)
+
+// gitleaksPatternsVersion is the version of the gitleaks patterns to request
+// from the pattern server. Keep this in sync with the Makefile.
+const gitleaksPatternsVersion = "8.18.2"
+
+// maxPatternsSize limits how much will be read from the pattern server
+const maxPatternsSize = 16 * 1024 * 1024
+
+// startupFetchTimeout bounds the fetch in Start since it blocks the cold
+// start. The cached or embedded patterns are used if it runs out and the
+// background refresh tries again later.
+const startupFetchTimeout = 5 * time.Second
+
+// Patterns holds the active gitleaks config. If a pattern server is
+// configured, the patterns are fetched from it and refreshed in the
+// background. The embedded patterns are used until that succeeds.
+type Patterns struct {
+	current atomic.Pointer[gitleaksconfig.Config]
+
+	mu              sync.Mutex
+	raw             string
+	client          *http.Client
+	serverURL       string
+	authToken       string
+	refreshInterval time.Duration
+	cachePath       string
+	stop            context.CancelFunc
+}
+
+// NewPatterns returns Patterns that always use the provided config
+func NewPatterns(cfg *gitleaksconfig.Config) *Patterns {
+	p := &Patterns{}
+	p.current.Store(cfg)
+	return p
+}
+
+// newPatterns returns Patterns using the embedded config that are kept up to
+// date with the pattern server if autofetch is enabled
+func newPatterns(ps *PatternServer) (*Patterns, error) {
+	gitleaksConfig, err := parseConfig(rawGitleaks)
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewPatterns(gitleaksConfig)
+	p.raw = rawGitleaks
+
+	if !ps.Autofetch {
+		return p, nil
+	}
+
+	p.serverURL = strings.TrimRight(ps.URL, "/")
+	p.authToken = ps.AuthToken
+	p.client = &http.Client{Timeout: 30 * time.Second}
+	p.refreshInterval, err = time.ParseDuration(ps.RefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern refresh interval: %w", err)
+	}
+
+	p.cachePath = ps.CachePath
+	if len(p.cachePath) == 0 {
+		p.cachePath = filepath.Join(os.TempDir(), "leaktk-gcs-filter", "gitleaks.toml")
+	}
+
+	return p, nil
+}
+
+// Gitleaks returns the active gitleaks config. Callers should hold onto the
+// returned value for the duration of a scan so a refresh doesn't change the
+// config part way through.
+func (p *Patterns) Gitleaks() *gitleaksconfig.Config {
+	return p.current.Load()
+}
+
+// swap validates the raw patterns and makes them active if they changed
+func (p *Patterns) swap(raw, source string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if raw == p.raw {
+		return nil
+	}
+
+	gitleaksConfig, err := parseConfig(raw)
+	if err != nil {
+		return err
+	}
+
+	p.current.Store(gitleaksConfig)
+	p.raw = raw
+	logging.Info("gitleaks patterns updated: source=%q", source)
+	return nil
+}
+
+// Refresh fetches the patterns from the pattern server and makes them active
+// if they're valid. The active patterns are left in place on any error.
+func (p *Patterns) Refresh(ctx context.Context) error {
+	if len(p.serverURL) == 0 {
+		return nil
+	}
+
+	url := p.serverURL + "/patterns/gitleaks/" + gitleaksPatternsVersion
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	if len(p.authToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+p.authToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("p.client.Do: %w", err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected pattern server response: status_code=%d", resp.StatusCode)
+	}
+
+	// Read one byte past the limit so a truncated response isn't mistaken
+	// for the full patterns
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPatternsSize+1))
+	if err != nil {
+		return fmt.Errorf("io.ReadAll(resp.Body): %w", err)
+	}
+
+	if len(body) > maxPatternsSize {
+		return fmt.Errorf("patterns response too large: max_size=%d", maxPatternsSize)
+	}
+
+	raw := string(body)
+	if err := p.swap(raw, url); err != nil {
+		return fmt.Errorf("invalid patterns from pattern server: %w", err)
+	}
+
+	if err := p.saveCache(raw); err != nil {
+		logging.Warning("could not cache gitleaks patterns: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (p *Patterns) loadCache() error {
+	data, err := os.ReadFile(p.cachePath)
+	if err != nil {
+		return err
+	}
+
+	return p.swap(string(data), p.cachePath)
+}
+
+func (p *Patterns) saveCache(raw string) error {
+	if err := os.MkdirAll(filepath.Dir(p.cachePath), 0o700); err != nil {
+		return err
+	}
+
+	tmpPath := p.cachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(raw), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, p.cachePath)
+}
+
+// Start loads any cached patterns, fetches the latest patterns and then keeps
+// refreshing them in the background until Stop is called. Failures are
+// logged and the previous patterns stay active. The first fetch blocks for at
+// most startupFetchTimeout.
+func (p *Patterns) Start(ctx context.Context) {
+	if len(p.serverURL) == 0 {
+		return
+	}
+
+	if err := p.loadCache(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Warning("could not load cached gitleaks patterns: %s", err.Error())
+	}
+
+	fetchCtx, cancelFetch := context.WithTimeout(ctx, startupFetchTimeout)
+	err := p.Refresh(fetchCtx)
+	cancelFetch()
+	if err != nil {
+		logging.Error("could not fetch gitleaks patterns: %w", err)
+	}
+
+	if p.refreshInterval == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.mu.Lock()
+	p.stop = cancel
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(p.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.Refresh(ctx); err != nil {
+					logging.Error("could not refresh gitleaks patterns: %w", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the background refresh
+func (p *Patterns) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		p.stop()
+		p.stop = nil
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPatterns = `
+[[rules]]
+id = "fetched-rule"
+regex = '''fetched-[a-z0-9]{16}'''
+`
+
+func newTestPatterns(t *testing.T, serverURL string) *Patterns {
+	t.Helper()
+
+	gitleaksConfig, err := parseConfig(rawGitleaks)
+	require.NoError(t, err)
+
+	p := NewPatterns(gitleaksConfig)
+	p.raw = rawGitleaks
+	p.client = http.DefaultClient
+	p.serverURL = serverURL
+	p.authToken = "test-token"
+	p.cachePath = filepath.Join(t.TempDir(), "gitleaks.toml")
+
+	return p
+}
+
+func TestPatternsRefresh(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/patterns/gitleaks/"+gitleaksPatternsVersion, r.URL.Path)
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	p := newTestPatterns(t, server.URL)
+	embedded := p.Gitleaks()
+
+	t.Run("ValidPatternsAreSwappedAndCached", func(t *testing.T) {
+		body = testPatterns
+		require.NoError(t, p.Refresh(context.Background()))
+		assert.NotSame(t, embedded, p.Gitleaks())
+		assert.Contains(t, p.Gitleaks().Rules, "fetched-rule")
+
+		cached, err := os.ReadFile(p.cachePath)
+		require.NoError(t, err)
+		assert.Equal(t, testPatterns, string(cached))
+	})
+
+	t.Run("OversizedPatternsAreRejected", func(t *testing.T) {
+		active := p.Gitleaks()
+		body = testPatterns + strings.Repeat("#", maxPatternsSize)
+		assert.ErrorContains(t, p.Refresh(context.Background()), "patterns response too large")
+		assert.Same(t, active, p.Gitleaks())
+	})
+
+	t.Run("InvalidPatternsAreRejected", func(t *testing.T) {
+		active := p.Gitleaks()
+		body = "not = [valid"
+		require.Error(t, p.Refresh(context.Background()))
+		assert.Same(t, active, p.Gitleaks())
+	})
+}
+
+func TestPatternsServerUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	p := newTestPatterns(t, server.URL)
+	embedded := p.Gitleaks()
+	require.Error(t, p.Refresh(context.Background()))
+	assert.Same(t, embedded, p.Gitleaks())
+
+	// The cached patterns are used in place of the embedded ones on startup
+	require.NoError(t, os.WriteFile(p.cachePath, []byte(testPatterns), 0o600))
+	p.refreshInterval = 0
+	p.Start(context.Background())
+	assert.Contains(t, p.Gitleaks().Rules, "fetched-rule")
+}
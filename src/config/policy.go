@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Policy actions choose what happens to objects with redactable leaks
+const (
+	// PolicyActionReport only reports the leaks
+	PolicyActionReport = "report"
+	// PolicyActionRedact redacts the object without quarantining it
+	PolicyActionRedact = "redact"
+	// PolicyActionQuarantine quarantines the object and then redacts it
+	PolicyActionQuarantine = "quarantine"
+)
+
+// Policy overrides the redaction and reporting behavior for the objects it
+// matches. Policies are only set in the config file since they're a list.
+type Policy struct {
+	// Name identifies the policy in logs and results
+	Name string `toml:"name" yaml:"name"`
+	// Bucket is the bucket name or a glob (e.g. "uploads-*") to match. If
+	// it's empty, every bucket matches.
+	Bucket string `toml:"bucket" yaml:"bucket"`
+	// Prefix matches object names starting with it
+	Prefix string `toml:"prefix" yaml:"prefix"`
+	// Glob matches object names with path.Match (e.g. "exports/*.csv")
+	Glob string `toml:"glob" yaml:"glob"`
+	// Action is "report", "redact", "quarantine" or empty to use the
+	// redactor config
+	Action string `toml:"action" yaml:"action"`
+	// ReporterKinds replaces the reporter kinds for the objects matched
+	ReporterKinds []string `toml:"reporter_kinds" yaml:"reporter_kinds"`
+	// AllowlistPaths are regexes for additional object names to skip
+	AllowlistPaths []string `toml:"allowlist_paths" yaml:"allowlist_paths"`
+
+	compileOnce    sync.Once
+	allowlistPaths []*regexp.Regexp
+	compileErrs    []error
+}
+
+// Matches returns true if the policy applies to the object
+func (p *Policy) Matches(bucketName, objectName string) bool {
+	if len(p.Bucket) > 0 {
+		if matched, _ := path.Match(p.Bucket, bucketName); !matched {
+			return false
+		}
+	}
+
+	if !strings.HasPrefix(objectName, p.Prefix) {
+		return false
+	}
+
+	if len(p.Glob) > 0 {
+		if matched, _ := path.Match(p.Glob, objectName); !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compile compiles the allowlist paths the first time it's called and
+// returns the ones that are invalid
+func (p *Policy) compile() []error {
+	p.compileOnce.Do(func() {
+		for _, rawPattern := range p.AllowlistPaths {
+			pattern, err := regexp.Compile(rawPattern)
+			if err != nil {
+				p.compileErrs = append(p.compileErrs, fmt.Errorf("policy %q has an invalid allowlist path: %w", p.Name, err))
+				continue
+			}
+
+			p.allowlistPaths = append(p.allowlistPaths, pattern)
+		}
+	})
+
+	return p.compileErrs
+}
+
+// Allowlisted returns true if the object name matches one of the policy's
+// allowlist paths
+func (p *Policy) Allowlisted(objectName string) bool {
+	p.compile()
+
+	for _, pattern := range p.allowlistPaths {
+		if pattern.MatchString(objectName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PolicyFor returns the first policy that matches the object or nil if none
+// of them do
+func (c *Config) PolicyFor(bucketName, objectName string) *Policy {
+	for _, policy := range c.Policies {
+		if policy.Matches(bucketName, objectName) {
+			return policy
+		}
+	}
+
+	return nil
+}
+
+// validate checks the policy
+func (p *Policy) validate(i int, redactorConfig *Redactor) []error {
+	var errs []error
+
+	name := p.Name
+	if len(name) == 0 {
+		name = fmt.Sprintf("policies[%d]", i)
+		errs = append(errs, fmt.Errorf("%s must have a name", name))
+	}
+
+	if _, err := path.Match(p.Bucket, ""); err != nil {
+		errs = append(errs, fmt.Errorf("policy %q has an invalid bucket glob: %w", name, err))
+	}
+
+	if _, err := path.Match(p.Glob, ""); err != nil {
+		errs = append(errs, fmt.Errorf("policy %q has an invalid glob: %w", name, err))
+	}
+
+	switch p.Action {
+	case "", PolicyActionReport:
+	case PolicyActionRedact, PolicyActionQuarantine:
+		if !redactorConfig.Enabled {
+			errs = append(errs, fmt.Errorf("policy %q can't %s objects if LEAKTK_GCS_FILTER_REDACTOR_ENABLED is false", name, p.Action))
+		}
+
+		if p.Action == PolicyActionQuarantine && len(redactorConfig.QuarantineBucketName) == 0 {
+			errs = append(errs, fmt.Errorf("LEAKTK_GCS_FILTER_REDACTOR_QUARANTINE_BUCKET_NAME must be set if policy %q quarantines objects", name))
+		}
+	default:
+		errs = append(errs, fmt.Errorf(
+			"policy %q action must be empty, %q, %q or %q",
+			name, PolicyActionReport, PolicyActionRedact, PolicyActionQuarantine,
+		))
+	}
+
+	return append(errs, p.compile()...)
+}
+
+func validatePolicies(policies []*Policy, redactorConfig *Redactor) []error {
+	var errs []error
+	names := make(map[string]bool, len(policies))
+
+	for i, policy := range policies {
+		if policy == nil {
+			errs = append(errs, errors.New("policies can't be empty"))
+			continue
+		}
+
+		if len(policy.Name) > 0 && names[policy.Name] {
+			errs = append(errs, fmt.Errorf("policy %q is defined more than once", policy.Name))
+		}
+
+		names[policy.Name] = true
+		errs = append(errs, policy.validate(i, redactorConfig)...)
+	}
+
+	return errs
+}
+
+// AllReporterKinds returns the default reporter kinds followed by any other
+// kinds used by the policies
+func (c *Config) AllReporterKinds() []string {
+	kinds := append([]string{}, c.Reporter.Kinds...)
+
+	for _, policy := range c.Policies {
+		if policy == nil {
+			continue
+		}
+
+		for _, kind := range policy.ReporterKinds {
+			if !slices.Contains(kinds, kind) {
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+
+	return kinds
+}
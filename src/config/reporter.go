@@ -0,0 +1,293 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SplunkFields lists the event details that can be sent to Splunk as
+// index-time fields
+var SplunkFields = []string{
+	"action",
+	"bucket",
+	"generation",
+	"leak_id",
+	"object",
+	"policy",
+	"quarantine_url",
+	"rule",
+	"severity",
+	"type",
+}
+
+// DefaultSplunkFields are the details sent if no fields are mapped
+var DefaultSplunkFields = []string{"bucket", "object", "rule", "leak_id", "action"}
+
+// reporterSetting describes a setting a reporter kind uses
+type reporterSetting struct {
+	name     string
+	required bool
+	value    func(r *Reporter) string
+	check    func(value string) error
+}
+
+// reporterKinds lists the settings used by each supported reporter kind.
+// Settings that aren't required are optional.
+var reporterKinds = map[string][]reporterSetting{
+	"Logger": nil,
+	"Splunk": {
+		{
+			name:     "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_COLLECTOR",
+			required: true,
+			value:    func(r *Reporter) string { return r.Splunk.Collector },
+			check:    checkCollectorURL,
+		},
+		{
+			name:     "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_TOKEN",
+			required: true,
+			value:    func(r *Reporter) string { return r.Splunk.Token },
+		},
+		{name: "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_HOST", value: func(r *Reporter) string { return r.Splunk.Host }},
+		{name: "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_INDEX", value: func(r *Reporter) string { return r.Splunk.Index }},
+		{name: "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_SOURCE", value: func(r *Reporter) string { return r.Splunk.Source }},
+		{name: "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_SOURCETYPE", value: func(r *Reporter) string { return r.Splunk.Sourcetype }},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_BATCH_SIZE",
+			value: func(r *Reporter) string { return intSetting(r.Splunk.BatchSize) },
+			check: checkPositiveInt,
+		},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_MAX_BATCH_BYTES",
+			value: func(r *Reporter) string { return intSetting(r.Splunk.MaxBatchBytes) },
+			check: checkPositiveInt,
+		},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_REQUEST_TIMEOUT",
+			value: func(r *Reporter) string { return r.Splunk.RequestTimeout },
+			check: checkPositiveDuration,
+		},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_RETRY_TIMEOUT",
+			value: func(r *Reporter) string { return r.Splunk.RetryTimeout },
+			check: checkNonNegativeDuration,
+		},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_CHANNEL",
+			value: func(r *Reporter) string { return r.Splunk.Channel },
+			check: checkChannel,
+		},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_ACK_TIMEOUT",
+			value: func(r *Reporter) string { return r.Splunk.AckTimeout },
+			check: checkPositiveDuration,
+		},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_ACK_POLL_INTERVAL",
+			value: func(r *Reporter) string { return r.Splunk.AckPollInterval },
+			check: checkPositiveDuration,
+		},
+		{name: "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_CA_FILE", value: func(r *Reporter) string { return r.Splunk.CAFile }},
+		{name: "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_CERT_FILE", value: func(r *Reporter) string { return r.Splunk.CertFile }},
+		{name: "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_KEY_FILE", value: func(r *Reporter) string { return r.Splunk.KeyFile }},
+		{
+			name:  "LEAKTK_GCS_FILTER_SPLUNK_REPORTER_PROXY_URL",
+			value: func(r *Reporter) string { return r.Splunk.ProxyURL },
+			check: checkProxyURL,
+		},
+	},
+	"BigQuery": {
+		{
+			name:     "LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_PROJECT_ID",
+			required: true,
+			value:    func(r *Reporter) string { return r.BigQuery.ProjectID },
+		},
+		{
+			name:     "LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_DATASET_ID",
+			required: true,
+			value:    func(r *Reporter) string { return r.BigQuery.DatasetID },
+		},
+		{
+			name:     "LEAKTK_GCS_FILTER_BIGQUERY_REPORTER_TABLE_ID",
+			required: true,
+			value:    func(r *Reporter) string { return r.BigQuery.TableID },
+		},
+	},
+}
+
+// ReporterKinds returns the supported reporter kinds in sorted order
+func ReporterKinds() []string {
+	kinds := make([]string, 0, len(reporterKinds))
+	for kind := range reporterKinds {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Strings(kinds)
+	return kinds
+}
+
+func checkCollectorURL(value string) error {
+	collectorURL, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+
+	if collectorURL.Scheme != "http" && collectorURL.Scheme != "https" {
+		return errors.New("the scheme must be http or https")
+	}
+
+	if len(collectorURL.Host) == 0 {
+		return errors.New("the host is missing")
+	}
+
+	return nil
+}
+
+// intSetting treats 0 as unset so that the default is used
+func intSetting(value int) string {
+	if value == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(value)
+}
+
+func checkPositiveInt(value string) error {
+	if parsed, err := strconv.Atoi(value); err != nil || parsed < 1 {
+		return errors.New("it must be a positive integer")
+	}
+
+	return nil
+}
+
+func checkPositiveDuration(value string) error {
+	if parsed, err := time.ParseDuration(value); err != nil || parsed <= 0 {
+		return errors.New("it must be a positive duration (e.g. 10s)")
+	}
+
+	return nil
+}
+
+func checkNonNegativeDuration(value string) error {
+	if parsed, err := time.ParseDuration(value); err != nil || parsed < 0 {
+		return errors.New("it must be a non-negative duration (e.g. 60s)")
+	}
+
+	return nil
+}
+
+func checkProxyURL(value string) error {
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return errors.New("the scheme must be http, https or socks5")
+	}
+
+	if len(proxyURL.Host) == 0 {
+		return errors.New("the host is missing")
+	}
+
+	return nil
+}
+
+func checkChannel(value string) error {
+	if err := uuid.Validate(value); err != nil {
+		return errors.New("it must be a GUID (e.g. from uuidgen)")
+	}
+
+	return nil
+}
+
+// unknownKindError explains which kinds are supported and suggests one if it
+// only differs by case
+func unknownKindError(kind string) error {
+	for _, supported := range ReporterKinds() {
+		if strings.EqualFold(kind, supported) {
+			return fmt.Errorf("LEAKTK_GCS_FILTER_REPORTER_KINDS has an unknown kind %q (did you mean %q?)", kind, supported)
+		}
+	}
+
+	return fmt.Errorf(
+		"LEAKTK_GCS_FILTER_REPORTER_KINDS has an unknown kind %q (supported kinds: %s)",
+		kind, strings.Join(ReporterKinds(), ", "),
+	)
+}
+
+// validate checks the settings for the kinds, which includes the kinds used
+// by policies
+func (r *Reporter) validate(kinds []string) []error {
+	var errs []error
+	seen := make(map[string]bool, len(kinds))
+
+	for _, kind := range kinds {
+		settings, ok := reporterKinds[kind]
+		if !ok {
+			errs = append(errs, unknownKindError(kind))
+			continue
+		}
+
+		if seen[kind] {
+			errs = append(errs, fmt.Errorf("LEAKTK_GCS_FILTER_REPORTER_KINDS lists %q more than once", kind))
+			continue
+		}
+
+		seen[kind] = true
+		for _, setting := range settings {
+			value := setting.value(r)
+
+			if len(value) == 0 {
+				if setting.required {
+					errs = append(errs, fmt.Errorf("%s must be set if LEAKTK_GCS_FILTER_REPORTER_KINDS includes %s", setting.name, kind))
+				}
+
+				continue
+			}
+
+			if setting.check != nil {
+				if err := setting.check(value); err != nil {
+					errs = append(errs, fmt.Errorf("%s is invalid: %w", setting.name, err))
+				}
+			}
+		}
+	}
+
+	if seen["Splunk"] {
+		errs = append(errs, r.Splunk.validate()...)
+	}
+
+	return errs
+}
+
+// validate checks the Splunk settings that depend on each other or aren't
+// set through env vars
+func (s *Splunk) validate() []error {
+	var errs []error
+
+	if (len(s.CertFile) == 0) != (len(s.KeyFile) == 0) {
+		errs = append(errs, errors.New("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_CERT_FILE and LEAKTK_GCS_FILTER_SPLUNK_REPORTER_KEY_FILE must be set together"))
+	}
+
+	for detail, name := range s.Fields {
+		if !slices.Contains(SplunkFields, detail) {
+			errs = append(errs, fmt.Errorf("splunk field %q is unknown (supported fields: %s)", detail, strings.Join(SplunkFields, ", ")))
+		}
+
+		if len(name) == 0 {
+			errs = append(errs, fmt.Errorf("splunk field %q must be mapped to a name", detail))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// Secret reference schemes that can be used in place of any config value
+const (
+	// FileSecretScheme references a file containing the value
+	// (e.g. file:///var/run/secrets/token)
+	FileSecretScheme = "file"
+	// SecretManagerSecretScheme references a Google Secret Manager secret
+	// (e.g. sm://projects/my-project/secrets/my-secret or
+	// sm://projects/my-project/secrets/my-secret/versions/2)
+	SecretManagerSecretScheme = "sm"
+)
+
+// SecretResolver returns the value a secret reference points to
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// FileSecretResolver resolves file:// references by reading the file. A
+// trailing newline is dropped since most tools add one.
+type FileSecretResolver struct{}
+
+// Resolve reads the file the reference points to
+func (FileSecretResolver) Resolve(_ context.Context, ref string) (string, error) {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if len(refURL.Host) > 0 && refURL.Host != "localhost" {
+		return "", fmt.Errorf("file references must be local: host=%q", refURL.Host)
+	}
+
+	data, err := os.ReadFile(refURL.Path) // #nosec G304 -- the path comes from the operator
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// SecretManagerResolver resolves sm:// references with Google Secret Manager.
+// The client is only created when the first reference is resolved.
+type SecretManagerResolver struct {
+	once    sync.Once
+	service *secretmanager.Service
+	err     error
+}
+
+// Resolve accesses the secret version the reference points to. The latest
+// version is used if the reference doesn't include one.
+func (r *SecretManagerResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	name := strings.TrimPrefix(ref, SecretManagerSecretScheme+"://")
+	parts := strings.Split(name, "/")
+
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		name += "/versions/latest"
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+	default:
+		return "", errors.New("secret manager references must look like sm://projects/PROJECT/secrets/SECRET[/versions/VERSION]")
+	}
+
+	r.once.Do(func() {
+		r.service, r.err = secretmanager.NewService(ctx)
+	})
+
+	if r.err != nil {
+		return "", fmt.Errorf("secretmanager.NewService: %w", r.err)
+	}
+
+	resp, err := r.service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("could not access secret: name=%q: %w", name, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("could not decode secret: name=%q: %w", name, err)
+	}
+
+	return string(data), nil
+}
+
+// defaultSecretResolvers returns the resolvers for each supported scheme
+func defaultSecretResolvers() map[string]SecretResolver {
+	return map[string]SecretResolver{
+		FileSecretScheme:          FileSecretResolver{},
+		SecretManagerSecretScheme: &SecretManagerResolver{},
+	}
+}
+
+// secretScheme returns the scheme of the value if it's a secret reference
+func secretScheme(value string, resolvers map[string]SecretResolver) (string, bool) {
+	scheme, _, found := strings.Cut(value, "://")
+	if !found {
+		return "", false
+	}
+
+	_, ok := resolvers[scheme]
+	return scheme, ok
+}
+
+// resolveSecrets replaces every string value in the config that is a secret
+// reference with the value it points to and returns every reference that
+// couldn't be resolved
+func (c *Config) resolveSecrets(ctx context.Context, resolvers map[string]SecretResolver) []error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var errs []error
+	resolve := func(key string, value reflect.Value) {
+		scheme, ok := secretScheme(value.String(), resolvers)
+		if !ok {
+			return
+		}
+
+		resolved, err := resolvers[scheme].Resolve(ctx, value.String())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not resolve the secret reference for %s: %w", key, err))
+			return
+		}
+
+		value.SetString(resolved)
+		if c.secretKeys == nil {
+			c.secretKeys = make(map[string]bool)
+		}
+		c.secretKeys[key] = true
+	}
+
+	walkStrings("", reflect.ValueOf(c), resolve)
+	return errs
+}
+
+// walkStrings calls fn for every settable string in the config structs using
+// the config file keys to name them
+func walkStrings(key string, value reflect.Value, fn func(key string, value reflect.Value)) {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if !value.IsNil() {
+			walkStrings(key, value.Elem(), fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+
+			if len(key) > 0 {
+				name = key + "." + name
+			}
+
+			walkStrings(name, value.Field(i), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			walkStrings(fmt.Sprintf("%s[%d]", key, i), value.Index(i), fn)
+		}
+	case reflect.String:
+		if value.CanSet() {
+			fn(key, value)
+		}
+	}
+}
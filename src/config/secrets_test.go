@@ -0,0 +1,68 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecretManager stands in for Secret Manager in tests
+type fakeSecretManager map[string]string
+
+func (f fakeSecretManager) Resolve(_ context.Context, ref string) (string, error) {
+	if value, ok := f[ref]; ok {
+		return value, nil
+	}
+
+	return "", errors.New("secret not found")
+}
+
+func TestSecretReferences(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("token-from-file\n"), 0o600))
+
+	resolvers := map[string]SecretResolver{
+		FileSecretScheme:          FileSecretResolver{},
+		SecretManagerSecretScheme: fakeSecretManager{"sm://projects/p/secrets/collector": "https://splunk.example.com/services/collector"},
+	}
+
+	t.Run("Resolved", func(t *testing.T) {
+		t.Setenv("LEAKTK_GCS_FILTER_REPORTER_KINDS", "Splunk")
+		t.Setenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_COLLECTOR", "sm://projects/p/secrets/collector")
+		t.Setenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_TOKEN", "file://"+tokenPath)
+
+		cfg, err := newConfig("", resolvers)
+		require.NoError(t, err)
+		assert.Equal(t, "https://splunk.example.com/services/collector", cfg.Reporter.Splunk.Collector)
+		assert.Equal(t, "token-from-file", cfg.Reporter.Splunk.Token)
+	})
+
+	t.Run("Unresolved", func(t *testing.T) {
+		t.Setenv("LEAKTK_GCS_FILTER_REPORTER_KINDS", "Splunk")
+		t.Setenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_COLLECTOR", "https://splunk.example.com/services/collector")
+		t.Setenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_TOKEN", "sm://projects/p/secrets/missing")
+
+		_, err := newConfig("", resolvers)
+		assert.ErrorContains(t, err, "could not resolve the secret reference for reporter.splunk.token")
+	})
+
+	t.Run("OtherSchemesAreLeftAlone", func(t *testing.T) {
+		t.Setenv("LEAKTK_GCS_FILTER_REPORTER_KINDS", "Splunk")
+		t.Setenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_COLLECTOR", "https://splunk.example.com/services/collector")
+		t.Setenv("LEAKTK_GCS_FILTER_SPLUNK_REPORTER_TOKEN", "token")
+
+		cfg, err := newConfig("", resolvers)
+		require.NoError(t, err)
+		assert.Equal(t, "https://splunk.example.com/services/collector", cfg.Reporter.Splunk.Collector)
+	})
+}
+
+func TestSecretManagerResolverRejectsInvalidReferences(t *testing.T) {
+	_, err := (&SecretManagerResolver{}).Resolve(context.Background(), "sm://my-secret")
+	assert.ErrorContains(t, err, "secret manager references must look like")
+}
@@ -0,0 +1,45 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// Store records what has already been processed so that events delivered
+// more than once are only handled once
+type Store interface {
+	// Seen returns true if the key has been marked as processed
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark records the key as processed
+	Mark(ctx context.Context, key string) error
+}
+
+// EventKey returns the key for a CloudEvent ID
+func EventKey(eventID string) string {
+	return "events/" + eventID
+}
+
+// ObjectKey returns the key for a generation of an object
+func ObjectKey(bucketName, objectName string, generation int64) string {
+	return "objects/" + bucketName + "/" + objectName + "#" + strconv.FormatInt(generation, 10)
+}
+
+// NewStore returns the store for the kind set in the config or nil if
+// deduplication is disabled. objects is used by the kinds that keep their
+// state in an object store.
+func NewStore(dc *config.Dedup, objects store.ObjectStore) (Store, error) {
+	switch dc.Kind {
+	case "":
+		return nil, nil
+	case "Memory":
+		return NewMemory(dc.MemorySize), nil
+	case "GCS":
+		return NewMarkers(objects, dc.BucketName, dc.Prefix), nil
+	default:
+		return nil, fmt.Errorf("unsupported dedup store: kind=%q", dc.Kind)
+	}
+}
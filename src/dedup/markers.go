@@ -0,0 +1,63 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// Markers is a Store that writes an empty marker object for each key so that
+// it's shared across instances and survives restarts. Use an object lifecycle
+// rule on the bucket to clean up old markers.
+type Markers struct {
+	objects    store.ObjectStore
+	bucketName string
+	prefix     string
+}
+
+// NewMarkers returns a Markers store that keeps markers in the bucket under
+// the prefix
+func NewMarkers(objects store.ObjectStore, bucketName, prefix string) *Markers {
+	return &Markers{
+		objects:    objects,
+		bucketName: bucketName,
+		prefix:     prefix,
+	}
+}
+
+// Seen returns true if the marker object for the key exists
+func (m *Markers) Seen(ctx context.Context, key string) (bool, error) {
+	_, err := m.objects.Attrs(ctx, m.bucketName, m.prefix+key, 0)
+	if errors.Is(err, store.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("objects.Attrs: %w", err)
+	}
+
+	return true, nil
+}
+
+// Mark writes the marker object for the key if it doesn't already exist
+func (m *Markers) Mark(ctx context.Context, key string) error {
+	writer := m.objects.NewWriter(ctx, m.bucketName, m.prefix+key, &store.ObjectAttrs{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"processed": time.Now().UTC().Format(time.RFC3339)},
+	}, store.Conditions{DoesNotExist: true})
+
+	err := writer.Close()
+	if errors.Is(err, store.ErrPreconditionFailed) {
+		// Another instance already marked it
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("writer.Close: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Memory is a Store that keeps the most recently marked keys in memory. It's
+// only shared by the events handled by a single instance.
+type Memory struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemory returns a Memory store that remembers up to size keys
+func NewMemory(size int) *Memory {
+	return &Memory{
+		size:    max(size, 1),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Seen returns true if the key is still in memory
+func (m *Memory) Seen(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.entries[key]; ok {
+		m.order.MoveToFront(entry)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Mark adds the key and evicts the least recently used key if it's full
+func (m *Memory) Mark(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.entries[key]; ok {
+		m.order.MoveToFront(entry)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(key)
+	if m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(string))
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(2)
+
+	require.NoError(t, m.Mark(ctx, "a"))
+	require.NoError(t, m.Mark(ctx, "b"))
+
+	// Touch "a" so that "b" is the least recently used
+	seen, err := m.Seen(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, seen)
+
+	require.NoError(t, m.Mark(ctx, "c"))
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		seen, err := m.Seen(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, seen, key)
+	}
+}
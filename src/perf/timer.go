@@ -15,3 +15,18 @@ func Timer(name string) func() {
 		logging.Info("%sTimer: duration=%v", name, time.Since(start))
 	}
 }
+
+// Timings collects the durations of named timers so they can be returned
+// along with a result
+type Timings map[string]time.Duration
+
+// Timer works like the package level Timer but also records the duration
+func (t Timings) Timer(name string) func() {
+	start := time.Now()
+
+	return func() {
+		duration := time.Since(start)
+		t[name] = duration
+		logging.Info("%sTimer: duration=%v", name, duration)
+	}
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// contentTypeMatches checks a content type against a pattern like
+// "application/json" or "video/*"
+func contentTypeMatches(contentType, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+
+	if prefix, found := strings.CutSuffix(pattern, "/*"); found {
+		return strings.HasPrefix(contentType, prefix+"/")
+	}
+
+	return contentType == pattern
+}
+
+func matchesAnyContentType(contentType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if contentTypeMatches(contentType, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkGates returns the reason the object shouldn't be scanned or an empty
+// string if it should be. Only the object's attributes are used so this can
+// run before the object is opened.
+func checkGates(gc *config.Gates, object *store.ObjectAttrs) string {
+	if gc == nil {
+		return ""
+	}
+
+	if gc.MaxObjectSize > 0 && object.Size > gc.MaxObjectSize {
+		return fmt.Sprintf("object size %d exceeds the max object size %d", object.Size, gc.MaxObjectSize)
+	}
+
+	name := strings.ToLower(object.Name)
+	for _, ext := range gc.ExtensionDeny {
+		if strings.HasSuffix(name, strings.ToLower(ext)) {
+			return fmt.Sprintf("extension %q is denied", ext)
+		}
+	}
+
+	// Objects without a content type are scanned since there's nothing to
+	// check
+	if len(object.ContentType) == 0 {
+		return ""
+	}
+
+	contentType, _, err := mime.ParseMediaType(object.ContentType)
+	if err != nil {
+		contentType = object.ContentType
+	}
+
+	contentType = strings.ToLower(contentType)
+	if matchesAnyContentType(contentType, gc.ContentTypeDeny) {
+		return fmt.Sprintf("content type %q is denied", contentType)
+	}
+
+	if len(gc.ContentTypeAllow) > 0 && !matchesAnyContentType(contentType, gc.ContentTypeAllow) {
+		return fmt.Sprintf("content type %q is not allowed", contentType)
+	}
+
+	return ""
+}
@@ -0,0 +1,418 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/googleapis/google-cloudevents-go/cloud/storagedata"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/dedup"
+	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/perf"
+	"github.com/leaktk/gcs-filter/redactor"
+	"github.com/leaktk/gcs-filter/reporter"
+	"github.com/leaktk/gcs-filter/scanner"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+var unmarshaller = protojson.UnmarshalOptions{
+	DiscardUnknown: true,
+	AllowPartial:   true,
+}
+
+// Pipeline scans objects and reports and redacts the leaks it finds. It's
+// shared by the cloud function and the other entrypoints so that they all
+// behave the same way.
+type Pipeline struct {
+	cfg      *config.Config
+	dedup    dedup.Store
+	objects  store.ObjectStore
+	reporter reporter.Reporter
+	redactor *redactor.Redactor
+}
+
+// NewPipeline sets up the services the pipeline needs from the config using
+// Google Cloud Storage as the object store
+func NewPipeline(ctx context.Context, cfg *config.Config) (*Pipeline, error) {
+	// Policies can pick other reporter kinds so set up every kind used
+	leakReporter, err := reporter.NewRouter(ctx, cfg.Reporter, cfg.AllReporterKinds())
+	if err != nil {
+		return nil, fmt.Errorf("reporter.NewRouter: %w", err)
+	}
+
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		_ = leakReporter.Close()
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+
+	p, err := NewPipelineWithStore(cfg, store.NewGCS(storageClient), leakReporter)
+	if err != nil {
+		_ = leakReporter.Close()
+		_ = storageClient.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// NewPipelineWithStore returns a pipeline using the provided object store and
+// reporter. The pipeline takes ownership of both and closes them in Close.
+func NewPipelineWithStore(cfg *config.Config, objects store.ObjectStore, leakReporter reporter.Reporter) (*Pipeline, error) {
+	dedupStore, err := dedup.NewStore(cfg.Dedup, objects)
+	if err != nil {
+		return nil, fmt.Errorf("dedup.NewStore: %w", err)
+	}
+
+	return &Pipeline{
+		cfg:      cfg,
+		dedup:    dedupStore,
+		objects:  objects,
+		reporter: leakReporter,
+		redactor: redactor.NewRedactor(cfg.Redactor, objects),
+	}, nil
+}
+
+// Store returns the object store the pipeline uses to access objects
+func (p *Pipeline) Store() store.ObjectStore {
+	return p.objects
+}
+
+// dedupMarker reports whether the object is a marker written by the GCS
+// dedup store. Markers are never scanned or marked so that a dedup bucket
+// that's also the trigger bucket doesn't loop.
+func (p *Pipeline) dedupMarker(object *store.ObjectAttrs) bool {
+	dc := p.cfg.Dedup
+	return dc.Kind == "GCS" && object.Bucket == dc.BucketName && strings.HasPrefix(object.Name, dc.Prefix)
+}
+
+// AnalyzeObject handles a CloudEvent for an object that was written to a
+// bucket and runs the object through the pipeline
+func (p *Pipeline) AnalyzeObject(ctx context.Context, e event.Event) error {
+	defer perf.Timer("AnalyzeObject")()
+	var data storagedata.StorageObjectData
+
+	endTimer := perf.Timer("Unmarshal")
+	if err := unmarshaller.Unmarshal(e.Data(), &data); err != nil {
+		endTimer()
+		return fmt.Errorf("protojson.Unmarshal: %w", err)
+	}
+
+	bucketName := data.GetBucket()
+	if bucketName == "" {
+		endTimer()
+		return errors.New("empty object bucket")
+	}
+
+	objectName := data.GetName()
+	if objectName == "" {
+		endTimer()
+		return errors.New("empty object name")
+	}
+
+	generation := data.GetGeneration()
+	if generation == 0 {
+		endTimer()
+		return errors.New("empty object generation")
+	}
+
+	object := &store.ObjectAttrs{
+		Bucket:          bucketName,
+		Name:            objectName,
+		Generation:      generation,
+		Size:            data.GetSize(),
+		ContentEncoding: data.GetContentEncoding(),
+		ContentType:     data.GetContentType(),
+		Metadata:        data.GetMetadata(),
+	}
+
+	if data.GetUpdated() != nil {
+		object.Updated = data.GetUpdated().AsTime()
+	}
+	endTimer()
+
+	// Marking the event for a dedup marker would write another marker and
+	// trigger another event if the dedup bucket is the trigger bucket
+	if p.dedupMarker(object) {
+		_, err := p.ProcessObject(ctx, object)
+		return err
+	}
+
+	// Events are delivered at least once so skip redeliveries
+	eventKey := dedup.EventKey(e.ID())
+	if e.ID() != "" && p.seen(ctx, eventKey) {
+		logging.Info("skipping analysis: outcome=%s event_id=%q object_name=\"%v\" generation=%d", OutcomeDuplicate, e.ID(), objectName, generation)
+		return nil
+	}
+
+	_, err := p.ProcessObject(ctx, object)
+	if err == nil && e.ID() != "" {
+		p.mark(ctx, eventKey)
+	}
+
+	return err
+}
+
+// Scan scans the generation of the object described by the attributes and
+// decides if it should be redacted without reporting or redacting anything.
+// The attributes are also checked against the gates before the object is
+// opened so they should come from the event or listing that found it.
+func Scan(ctx context.Context, cfg *config.Config, objects store.ObjectStore, object *store.ObjectAttrs) *ScanResult {
+	bucketName, objectName, generation := object.Bucket, object.Name, object.Generation
+	result := newScanResult(bucketName, objectName, generation)
+	endTimer := result.Timings.Timer("ScanObject")
+	defer endTimer()
+
+	if result.policy = cfg.PolicyFor(bucketName, objectName); result.policy != nil {
+		result.Policy = result.policy.Name
+	}
+
+	// Use the same patterns for the whole scan even if they're refreshed
+	gitleaksConfig := cfg.Patterns.Gitleaks()
+	if scanner.ShouldSkipPath(gitleaksConfig, objectName) || (result.policy != nil && result.policy.Allowlisted(objectName)) {
+		logging.Info("skipping analysis: outcome=%s object_name=\"%v\"", OutcomeSkipped, objectName)
+		result.Outcome = OutcomeSkipped
+		return result
+	}
+
+	if reason := checkGates(cfg.Gates, object); reason != "" {
+		logging.Info("skipping analysis: outcome=%s reason=%q object_name=\"%v\" generation=%d", OutcomeNotScanned, reason, objectName, generation)
+		result.Outcome = OutcomeNotScanned
+		result.NotScannedReason = reason
+		return result
+	}
+
+	logging.Info("starting analysis: object_name=\"%v\" generation=%d", objectName, generation)
+	// Pin the generation so that a newer upload isn't scanned in place of the
+	// one being processed
+	leaks, err := scanner.Scan(ctx, gitleaksConfig, objects, bucketName, objectName, generation)
+	if err != nil {
+		if errors.Is(err, store.ErrNotExist) {
+			logging.Info("skipping analysis: outcome=%s object_name=\"%v\" generation=%d", OutcomeSuperseded, objectName, generation)
+			result.Outcome = OutcomeSuperseded
+			return result
+		}
+
+		logging.Error("scanner.Scan: %w", err)
+		result.ScanErr = err
+	}
+
+	result.record(cfg.Redactor, leaks, err)
+	return result
+}
+
+// ScanReader runs the same scan and redaction decision as Scan against
+// content that doesn't live in a bucket (e.g. a local file). No policy or
+// gates apply to it since they're matched against buckets and object
+// attributes. sourceURL is used in place of the gs:// URL for the leaks.
+func ScanReader(ctx context.Context, cfg *config.Config, sourceURL, path string, content io.Reader) *ScanResult {
+	result := newScanResult("", path, 0)
+	endTimer := result.Timings.Timer("ScanObject")
+	defer endTimer()
+
+	leaks, err := scanner.ScanReader(ctx, cfg.Patterns.Gitleaks(), sourceURL, path, content)
+	if err != nil {
+		logging.Error("scanner.ScanReader: %w", err)
+		result.ScanErr = err
+	}
+
+	result.record(cfg.Redactor, leaks, err)
+	return result
+}
+
+// ProcessObject scans a generation of an object, reports any leaks found and
+// redacts the object if needed. Scan errors are recorded in the result and
+// only returned (wrapped in ErrRetryable) if they're transient and retries
+// are enabled. Permanent scan errors are dead-lettered instead. Reporting
+// errors are recorded in the result too and returned (wrapped in
+// ErrRetryable) if reporter retries are enabled and the object wasn't
+// redacted.
+func (p *Pipeline) ProcessObject(ctx context.Context, object *store.ObjectAttrs) (*ScanResult, error) {
+	bucketName, objectName, generation := object.Bucket, object.Name, object.Generation
+	if p.dedupMarker(object) {
+		result := newScanResult(bucketName, objectName, generation)
+		result.Outcome = OutcomeSkipped
+		logResult(result)
+		return result, nil
+	}
+
+	objectKey := dedup.ObjectKey(bucketName, objectName, generation)
+	if p.seen(ctx, objectKey) {
+		result := newScanResult(bucketName, objectName, generation)
+		result.Outcome = OutcomeDuplicate
+		logResult(result)
+		return result, nil
+	}
+
+	if p.redactor.Restored(ctx, object) {
+		result := newScanResult(bucketName, objectName, generation)
+		result.Outcome = OutcomeRestored
+		logResult(result)
+		p.mark(ctx, objectKey)
+		return result, nil
+	}
+
+	result := Scan(ctx, p.cfg, p.objects, object)
+	defer logResult(result)
+
+	var err error
+	if result.ShouldRedact() {
+		if opts, ok := RedactOptions(p.cfg.Redactor, result); ok {
+			err = p.redact(ctx, result, opts)
+		}
+	}
+
+	records := result.Leaks
+	if result.Outcome == OutcomeNotScanned {
+		records = append(records, scanner.NewNotScanned(bucketName, objectName, generation, result.NotScannedReason))
+	}
+
+	// Nothing is gained from scanning a redacted object again
+	if result.ScanErr != nil && result.Outcome != OutcomeRedacted {
+		if p.cfg.ScanErrors.Retry && isTransient(result.ScanErr) {
+			logging.Warning("transient scan error: object_name=\"%v\" generation=%d err=%q", objectName, generation, result.ScanErr)
+			result.Retryable = true
+			err = errors.Join(err, fmt.Errorf("%w: %w", ErrRetryable, result.ScanErr))
+		} else if record := p.deadLetter(ctx, result); record != nil {
+			records = append(records, record)
+		}
+	}
+
+	if len(records) > 0 {
+		// Record the object and what happened to it with each record
+		for _, record := range records {
+			record.Data.BucketName = bucketName
+			record.Data.Generation = generation
+			record.Data.RedactionMode = result.Redaction.Mode
+			record.Data.QuarantineURL = result.Redaction.QuarantineURL
+			if !object.Updated.IsZero() {
+				record.Data.ObjectUpdated = object.Updated.UTC().Format(time.RFC3339Nano)
+			}
+		}
+
+		endTimer := result.Timings.Timer("ReportLeaks")
+		result.ReportErr = p.report(ctx, result, records)
+		endTimer()
+	}
+
+	if result.ReportErr != nil {
+		logging.Error("could not report: record_count=%d object_name=\"%v\" generation=%d err=%w", len(records), objectName, generation, result.ReportErr)
+
+		// A redelivered event can't report the records again if the object
+		// was redacted since the generation no longer exists
+		if p.cfg.Reporter.RetryFailures && result.Outcome != OutcomeRedacted {
+			result.Retryable = true
+			err = errors.Join(err, fmt.Errorf("%w: %w", ErrRetryable, result.ReportErr))
+		}
+	}
+
+	if err == nil {
+		p.mark(ctx, objectKey)
+	}
+
+	return result, err
+}
+
+// seen returns true if the key was already processed. Errors are logged and
+// treated as not seen so that the object is still scanned.
+func (p *Pipeline) seen(ctx context.Context, key string) bool {
+	if p.dedup == nil {
+		return false
+	}
+
+	seen, err := p.dedup.Seen(ctx, key)
+	if err != nil {
+		logging.Error("could not check if processed: key=%q err=%w", key, err)
+		return false
+	}
+
+	return seen
+}
+
+// mark records the key as processed. Errors are only logged since the worst
+// case is processing it again.
+func (p *Pipeline) mark(ctx context.Context, key string) {
+	if p.dedup == nil {
+		return
+	}
+
+	if err := p.dedup.Mark(ctx, key); err != nil {
+		logging.Error("could not mark as processed: key=%q err=%w", key, err)
+	}
+}
+
+// report sends the records to the reporter kinds picked by the policy or the
+// default ones
+func (p *Pipeline) report(ctx context.Context, result *ScanResult, records []*scanner.Leak) error {
+	if result.policy != nil && len(result.policy.ReporterKinds) > 0 {
+		if router, ok := p.reporter.(reporter.KindsReporter); ok {
+			return router.ReportTo(ctx, result.policy.ReporterKinds, records)
+		}
+	}
+
+	return p.reporter.Report(ctx, records)
+}
+
+func (p *Pipeline) redact(ctx context.Context, result *ScanResult, opts redactor.Options) error {
+	endTimer := result.Timings.Timer("RedactObject")
+	redaction, err := p.redactor.Redact(ctx, result.BucketName, result.ObjectName, result.Generation, result.RedactableLeaks(), opts)
+	endTimer()
+	result.Redaction = redaction
+
+	if errors.Is(err, redactor.ErrSuperseded) {
+		logging.Info("skipping redaction: outcome=%s object_name=\"%v\" generation=%d err=%q", OutcomeSuperseded, result.ObjectName, result.Generation, err)
+		result.Outcome = OutcomeSuperseded
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	result.Outcome = OutcomeRedacted
+	return nil
+}
+
+// deadLetter handles an object that permanently failed to scan. It
+// quarantines the object if configured to and returns the failure record to
+// report if dead-lettering is enabled.
+func (p *Pipeline) deadLetter(ctx context.Context, result *ScanResult) *scanner.Leak {
+	logging.Error("permanent scan error: object_name=\"%v\" generation=%d err=%w", result.ObjectName, result.Generation, result.ScanErr)
+
+	if p.cfg.ScanErrors.Quarantine && !result.Redaction.Quarantined {
+		quarantineURL, err := p.redactor.Quarantine(ctx, result.BucketName, result.ObjectName, result.Generation, "scan error: "+result.ScanErr.Error())
+		if err != nil {
+			logging.Error("could not quarantine object: object_name=\"%v\" generation=%d err=%w", result.ObjectName, result.Generation, err)
+		} else {
+			result.Redaction.Quarantined = true
+			result.Redaction.QuarantineURL = quarantineURL
+		}
+	}
+
+	if !p.cfg.ScanErrors.DeadLetter {
+		return nil
+	}
+
+	result.DeadLettered = true
+	return scanner.NewScanFailure(result.BucketName, result.ObjectName, result.Generation, result.ScanErr)
+}
+
+func logResult(result *ScanResult) {
+	logging.Info(
+		"analysis complete: outcome=%s leak_count=%d redaction_mode=%q quarantined=%t policy=%q object_name=\"%v\" generation=%d",
+		result.Outcome, len(result.Leaks), result.Redaction.Mode, result.Redaction.Quarantined, result.Policy, result.ObjectName, result.Generation,
+	)
+}
+
+// Close cleans up the services used by the pipeline
+func (p *Pipeline) Close() error {
+	return errors.Join(p.reporter.Close(), p.objects.Close())
+}
@@ -0,0 +1,1234 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gitleaksconfig "github.com/zricethezav/gitleaks/v8/config"
+
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/redactor"
+	"github.com/leaktk/gcs-filter/reporter"
+	"github.com/leaktk/gcs-filter/scanner"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+const (
+	testBucketName       = "uploads"
+	testQuarantineBucket = "quarantine"
+	testSecret           = "leaktk_test_secret_0123456789abcdef"
+	testTestingSecret    = "leaktk_testing_rule_0123456789abcdef"
+	removedNotice        = "This file contained potentially sensitive information and has been removed.\n"
+)
+
+const testGitleaksConfig = `
+[[rules]]
+id = "leaktk-test-secret"
+description = "LeakTK Test Secret"
+regex = '''leaktk_test_secret_[a-z0-9]{16}'''
+keywords = ["leaktk_test_secret_"]
+tags = ["type:secret"]
+
+[[rules]]
+id = "leaktk-testing-rule"
+description = "LeakTK Testing Rule"
+regex = '''leaktk_testing_rule_[a-z0-9]{16}'''
+keywords = ["leaktk_testing_rule_"]
+tags = ["type:secret", "group:leaktk-testing"]
+
+[[rules]]
+id = "leaktk-test-assignment"
+description = "LeakTK Test Assignment"
+regex = '''leaktk_key = "([a-z0-9]{16})"'''
+secretGroup = 1
+keywords = ["leaktk_key"]
+tags = ["type:secret"]
+
+[[allowlists]]
+paths = ['''^allowlisted/''']
+`
+
+// captureReporter keeps the leaks it's sent so tests can check them
+type captureReporter struct {
+	mu    sync.Mutex
+	leaks []*scanner.Leak
+	// kinds are the reporter kinds picked by a policy for the last report
+	kinds []string
+	// err is returned from every report
+	err error
+}
+
+func (r *captureReporter) Report(_ context.Context, leaks []*scanner.Leak) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leaks = append(r.leaks, leaks...)
+	r.kinds = nil
+	return r.err
+}
+
+func (r *captureReporter) ReportTo(_ context.Context, kinds []string, leaks []*scanner.Leak) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leaks = append(r.leaks, leaks...)
+	r.kinds = kinds
+	return r.err
+}
+
+func (r *captureReporter) Close() error {
+	return nil
+}
+
+func testConfig(t *testing.T, redactorConfig *config.Redactor) *config.Config {
+	t.Helper()
+
+	var vc gitleaksconfig.ViperConfig
+	_, err := toml.Decode(testGitleaksConfig, &vc)
+	require.NoError(t, err)
+
+	gitleaksConfig, err := vc.Translate()
+	require.NoError(t, err)
+
+	return &config.Config{
+		Patterns: config.NewPatterns(&gitleaksConfig),
+		Dedup:    &config.Dedup{},
+		Gates:    &config.Gates{},
+		Redactor: redactorConfig,
+		Reporter: &config.Reporter{Kinds: []string{"Logger"}},
+		ScanErrors: &config.ScanErrors{
+			Retry:      true,
+			DeadLetter: true,
+			Quarantine: redactorConfig.QuarantineBucketName != "",
+		},
+	}
+}
+
+// failingStore fails to open objects with err
+type failingStore struct {
+	*store.Memory
+	err error
+}
+
+func (s *failingStore) NewReader(_ context.Context, _, _ string, _ int64) (io.ReadCloser, error) {
+	return nil, s.err
+}
+
+type harness struct {
+	pipeline *Pipeline
+	objects  *store.Memory
+	reporter *captureReporter
+}
+
+func newHarness(t *testing.T, redactorConfig *config.Redactor) *harness {
+	t.Helper()
+
+	h := &harness{
+		objects:  store.NewMemory(),
+		reporter: &captureReporter{},
+	}
+
+	var err error
+	h.pipeline, err = NewPipelineWithStore(testConfig(t, redactorConfig), h.objects, h.reporter)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, h.pipeline.Close())
+	})
+
+	return h
+}
+
+func quarantineRedactor() *config.Redactor {
+	return &config.Redactor{
+		Enabled:              true,
+		Mode:                 config.RedactorModeNotice,
+		Quarantine:           true,
+		QuarantineBucketName: testQuarantineBucket,
+	}
+}
+
+// finalizedEvent builds an event like the ones sent by Eventarc when an
+// object is written to a bucket
+func finalizedEvent(t *testing.T, bucketName, objectName string, generation int64) event.Event {
+	t.Helper()
+
+	e := event.New()
+	e.SetID("test-event")
+	e.SetSource("//storage.googleapis.com/projects/_/buckets/" + bucketName)
+	e.SetType("google.cloud.storage.object.v1.finalized")
+
+	data := map[string]string{
+		"bucket":     bucketName,
+		"name":       objectName,
+		"generation": strconv.FormatInt(generation, 10),
+	}
+
+	require.NoError(t, e.SetData(event.ApplicationJSON, data))
+	return e
+}
+
+// objectRef returns the attributes an event for the object would provide
+func objectRef(objectName string, generation int64) *store.ObjectAttrs {
+	return &store.ObjectAttrs{Bucket: testBucketName, Name: objectName, Generation: generation}
+}
+
+func (h *harness) upload(objectName string, content []byte) int64 {
+	return h.objects.Put(testBucketName, objectName, &store.ObjectAttrs{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"owner": "test"},
+	}, content)
+}
+
+func (h *harness) analyze(t *testing.T, objectName string, generation int64) error {
+	t.Helper()
+	return h.pipeline.AnalyzeObject(context.Background(), finalizedEvent(t, testBucketName, objectName, generation))
+}
+
+func (h *harness) content(t *testing.T, bucketName, objectName string) string {
+	t.Helper()
+
+	content, err := h.objects.Content(bucketName, objectName)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func (h *harness) assertQuarantined(t *testing.T, objectName string, generation int64, expected string) {
+	t.Helper()
+	quarantineObjectName := redactor.QuarantineObjectName(testBucketName, objectName, generation)
+	assert.Equal(t, expected, h.content(t, testQuarantineBucket, quarantineObjectName))
+}
+
+func (h *harness) assertNotQuarantined(t *testing.T, objectName string, generation int64) {
+	t.Helper()
+	quarantineObjectName := redactor.QuarantineObjectName(testBucketName, objectName, generation)
+	_, err := h.objects.Content(testQuarantineBucket, quarantineObjectName)
+	assert.ErrorIs(t, err, store.ErrNotExist)
+}
+
+func zipped(t *testing.T, name, content string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	file, err := archive.Create(name)
+	require.NoError(t, err)
+	_, err = file.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+
+	return buf.Bytes()
+}
+
+func TestAnalyzeObjectCleanObject(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	content := "nothing to see here\n"
+	generation := h.upload("clean.txt", []byte(content))
+
+	require.NoError(t, h.analyze(t, "clean.txt", generation))
+
+	assert.Empty(t, h.reporter.leaks)
+	assert.Equal(t, content, h.content(t, testBucketName, "clean.txt"))
+	h.assertNotQuarantined(t, "clean.txt", generation)
+}
+
+func TestAnalyzeObjectRedactsProductionSecrets(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	content := "token = " + testSecret + "\n"
+	generation := h.upload("config.txt", []byte(content))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	leak := h.reporter.leaks[0]
+	assert.Equal(t, testSecret, leak.Data.Offender)
+	assert.Equal(t, "gs://uploads/config.txt#L1", leak.Data.LeakURL)
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "config.txt"))
+	h.assertQuarantined(t, "config.txt", generation, content)
+}
+
+func TestAnalyzeObjectReportsTestingRulesWithoutRedacting(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	content := "token = " + testTestingSecret + "\n"
+	generation := h.upload("testing.txt", []byte(content))
+
+	require.NoError(t, h.analyze(t, "testing.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	assert.Contains(t, h.reporter.leaks[0].Data.DataClasses, "group:leaktk-testing")
+	assert.Equal(t, content, h.content(t, testBucketName, "testing.txt"))
+	h.assertNotQuarantined(t, "testing.txt", generation)
+}
+
+func TestAnalyzeObjectSkipsAllowlistedPaths(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	content := "token = " + testSecret + "\n"
+	generation := h.upload("allowlisted/config.txt", []byte(content))
+
+	require.NoError(t, h.analyze(t, "allowlisted/config.txt", generation))
+
+	assert.Empty(t, h.reporter.leaks)
+	assert.Equal(t, content, h.content(t, testBucketName, "allowlisted/config.txt"))
+}
+
+func TestAnalyzeObjectScansArchives(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	content := zipped(t, "nested/config.txt", "token = "+testSecret+"\n")
+	generation := h.upload("archive.zip", content)
+
+	require.NoError(t, h.analyze(t, "archive.zip", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	assert.Equal(t, testSecret, h.reporter.leaks[0].Data.Offender)
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "archive.zip"))
+	h.assertQuarantined(t, "archive.zip", generation, string(content))
+}
+
+func TestAnalyzeObjectScansEncodedContent(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	encoded := base64.StdEncoding.EncodeToString([]byte("token = " + testSecret))
+	generation := h.upload("encoded.txt", []byte("data = "+encoded+"\n"))
+
+	require.NoError(t, h.analyze(t, "encoded.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	assert.Equal(t, testSecret, h.reporter.leaks[0].Data.Offender)
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "encoded.txt"))
+}
+
+func TestAnalyzeObjectMasksSecrets(t *testing.T) {
+	h := newHarness(t, &config.Redactor{Enabled: true, Mode: config.RedactorModeMask})
+	generation := h.upload("config.txt", []byte("user = leaktk\ntoken = "+testSecret+"\n"))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	leak := h.reporter.leaks[0]
+	assert.Equal(t, "user = leaktk\ntoken = REDACTED("+leak.ID+")\n", h.content(t, testBucketName, "config.txt"))
+
+	attrs, err := h.objects.Attrs(context.Background(), testBucketName, "config.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", attrs.ContentType)
+	assert.Equal(t, map[string]string{"owner": "test"}, attrs.Metadata)
+}
+
+func TestAnalyzeObjectMasksSecretsWhereTheyWereFound(t *testing.T) {
+	h := newHarness(t, &config.Redactor{Enabled: true, Mode: config.RedactorModeMask})
+	content := "leaktk_key = \"0123456789abcdef\"\nchecksum: 0123456789abcdef\n"
+	generation := h.upload("config.txt", []byte(content))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	// Only the match is masked and not the same text on the other line
+	require.Len(t, h.reporter.leaks, 1)
+	leak := h.reporter.leaks[0]
+	assert.Equal(t, "0123456789abcdef", leak.Data.Offender)
+	assert.Equal(t, "leaktk_key = \"REDACTED("+leak.ID+")\"\nchecksum: 0123456789abcdef\n", h.content(t, testBucketName, "config.txt"))
+
+	// Each copy of a secret is masked with the ID of its own leak
+	generation = h.upload("repeated.txt", []byte("a = "+testSecret+"\nb = "+testSecret+"\n"))
+	require.NoError(t, h.analyze(t, "repeated.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 3)
+	first, second := h.reporter.leaks[1], h.reporter.leaks[2]
+	if first.Data.LineNumber > second.Data.LineNumber {
+		first, second = second, first
+	}
+
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, "a = REDACTED("+first.ID+")\nb = REDACTED("+second.ID+")\n", h.content(t, testBucketName, "repeated.txt"))
+}
+
+func TestAnalyzeObjectMaskFallsBackForEncodedContent(t *testing.T) {
+	h := newHarness(t, &config.Redactor{Enabled: true, Mode: config.RedactorModeMask})
+	encoded := base64.StdEncoding.EncodeToString([]byte("token = " + testSecret))
+	generation := h.upload("encoded.txt", []byte("data = "+encoded+"\n"))
+
+	require.NoError(t, h.analyze(t, "encoded.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "encoded.txt"))
+	assert.Equal(t, config.RedactorModeNotice, h.reporter.leaks[0].Data.RedactionMode)
+}
+
+func TestAnalyzeObjectMaskFallsBackForRawAndEncodedSecrets(t *testing.T) {
+	h := newHarness(t, &config.Redactor{Enabled: true, Mode: config.RedactorModeMask})
+	encoded := base64.StdEncoding.EncodeToString([]byte("token = " + testSecret))
+	generation := h.upload("mixed.txt", []byte("a = "+testSecret+"\ndata = "+encoded+"\n"))
+
+	require.NoError(t, h.analyze(t, "mixed.txt", generation))
+
+	// Masking the raw copy would leave the encoded one behind
+	require.Len(t, h.reporter.leaks, 2)
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "mixed.txt"))
+	for _, leak := range h.reporter.leaks {
+		assert.Equal(t, config.RedactorModeNotice, leak.Data.RedactionMode)
+	}
+}
+
+func TestProcessObjectRedactionModes(t *testing.T) {
+	tests := []struct {
+		mode        string
+		quarantine  bool
+		deleted     bool
+		quarantined bool
+	}{
+		{mode: config.RedactorModeNotice},
+		{mode: config.RedactorModeMask},
+		{mode: config.RedactorModeDelete, deleted: true},
+		{mode: config.RedactorModeDelete, quarantine: true, deleted: true, quarantined: true},
+		// Move always quarantines the object before deleting it
+		{mode: config.RedactorModeMove, deleted: true, quarantined: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+"/quarantine="+strconv.FormatBool(tt.quarantine), func(t *testing.T) {
+			h := newHarness(t, &config.Redactor{
+				Enabled:              true,
+				Mode:                 tt.mode,
+				Quarantine:           tt.quarantine,
+				QuarantineBucketName: testQuarantineBucket,
+			})
+			content := "token = " + testSecret + "\n"
+			generation := h.upload("config.txt", []byte(content))
+
+			result, err := h.pipeline.ProcessObject(context.Background(), objectRef("config.txt", generation))
+			require.NoError(t, err)
+
+			assert.Equal(t, OutcomeRedacted, result.Outcome)
+			assert.Equal(t, tt.mode, result.Redaction.Mode)
+			assert.Equal(t, tt.deleted, result.Redaction.Deleted)
+			assert.Equal(t, tt.quarantined, result.Redaction.Quarantined)
+
+			require.Len(t, h.reporter.leaks, 1)
+			assert.Equal(t, tt.mode, h.reporter.leaks[0].Data.RedactionMode)
+
+			_, err = h.objects.Content(testBucketName, "config.txt")
+			if tt.deleted {
+				assert.ErrorIs(t, err, store.ErrNotExist)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tt.quarantined {
+				h.assertQuarantined(t, "config.txt", generation, content)
+			} else {
+				h.assertNotQuarantined(t, "config.txt", generation)
+			}
+		})
+	}
+}
+
+func TestProcessObjectRecordsTheObject(t *testing.T) {
+	h := newHarness(t, &config.Redactor{})
+	generation := h.upload("config.txt", []byte("token = "+testSecret+"\n"))
+	object := objectRef("config.txt", generation)
+	object.Updated = time.Date(2024, 5, 1, 12, 30, 0, 500_000_000, time.UTC)
+
+	_, err := h.pipeline.ProcessObject(context.Background(), object)
+	require.NoError(t, err)
+
+	require.Len(t, h.reporter.leaks, 1)
+	leak := h.reporter.leaks[0]
+	assert.Equal(t, testBucketName, leak.Data.BucketName)
+	assert.Equal(t, generation, leak.Data.Generation)
+	assert.Equal(t, "2024-05-01T12:30:00.5Z", leak.Data.ObjectUpdated)
+}
+
+func TestAnalyzeObjectCustomNotice(t *testing.T) {
+	h := newHarness(t, &config.Redactor{
+		Enabled:    true,
+		Mode:       config.RedactorModeNotice,
+		Notice:     "{{.ObjectName}} was removed because of: {{range .LeakIDs}}{{.}} {{end}}\nContact: {{.ContactURL}}\n",
+		ContactURL: "https://example.com/security",
+	})
+	generation := h.upload("config.txt", []byte("token = "+testSecret+"\n"))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	expected := "config.txt was removed because of: " + h.reporter.leaks[0].ID + " \nContact: https://example.com/security\n"
+	assert.Equal(t, expected, h.content(t, testBucketName, "config.txt"))
+}
+
+func TestAnalyzeObjectDoesNotRedactWhenDisabled(t *testing.T) {
+	h := newHarness(t, &config.Redactor{Enabled: false, Mode: config.RedactorModeNotice})
+	content := "token = " + testSecret + "\n"
+	generation := h.upload("config.txt", []byte(content))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	require.Len(t, h.reporter.leaks, 1)
+	assert.Equal(t, content, h.content(t, testBucketName, "config.txt"))
+}
+
+func TestAnalyzeObjectSkipsSupersededGenerations(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	generation := h.upload("config.txt", []byte("token = "+testSecret+"\n"))
+	h.upload("config.txt", []byte("clean\n"))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	assert.Empty(t, h.reporter.leaks)
+	assert.Equal(t, "clean\n", h.content(t, testBucketName, "config.txt"))
+	h.assertNotQuarantined(t, "config.txt", generation)
+}
+
+func TestAnalyzeObjectRejectsInvalidEvents(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	ctx := context.Background()
+
+	assert.ErrorContains(t, h.pipeline.AnalyzeObject(ctx, finalizedEvent(t, "", "config.txt", 1)), "empty object bucket")
+	assert.ErrorContains(t, h.pipeline.AnalyzeObject(ctx, finalizedEvent(t, testBucketName, "", 1)), "empty object name")
+	assert.ErrorContains(t, h.pipeline.AnalyzeObject(ctx, finalizedEvent(t, testBucketName, "config.txt", 0)), "empty object generation")
+}
+
+func TestProcessObjectOutcomes(t *testing.T) {
+	tests := []struct {
+		name        string
+		objectName  string
+		content     string
+		supersede   bool
+		outcome     Outcome
+		leakCount   int
+		quarantined bool
+	}{
+		{name: "clean", objectName: "clean.txt", content: "clean\n", outcome: OutcomeClean},
+		{name: "redacted", objectName: "secret.txt", content: testSecret, outcome: OutcomeRedacted, leakCount: 1, quarantined: true},
+		{name: "reported", objectName: "testing.txt", content: testTestingSecret, outcome: OutcomeLeaksReported, leakCount: 1},
+		{name: "skipped", objectName: "allowlisted/secret.txt", content: testSecret, outcome: OutcomeSkipped},
+		{name: "superseded", objectName: "secret.txt", content: testSecret, supersede: true, outcome: OutcomeSuperseded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, quarantineRedactor())
+			generation := h.upload(tt.objectName, []byte(tt.content))
+			if tt.supersede {
+				h.upload(tt.objectName, []byte("clean\n"))
+			}
+
+			result, err := h.pipeline.ProcessObject(context.Background(), objectRef(tt.objectName, generation))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.outcome, result.Outcome)
+			assert.Len(t, result.Leaks, tt.leakCount)
+			assert.Len(t, result.Decisions, tt.leakCount)
+			assert.Equal(t, tt.quarantined, result.Redaction.Quarantined)
+			assert.Contains(t, result.Timings, "ScanObject")
+		})
+	}
+}
+
+func TestProcessObjectRetriesTransientScanErrors(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	objects := &failingStore{Memory: h.objects, err: context.DeadlineExceeded}
+	p, err := NewPipelineWithStore(h.pipeline.cfg, objects, h.reporter)
+	require.NoError(t, err)
+	generation := h.upload("config.txt", []byte(testSecret))
+
+	result, err := p.ProcessObject(context.Background(), objectRef("config.txt", generation))
+
+	require.ErrorIs(t, err, ErrRetryable)
+	assert.Equal(t, OutcomeScanError, result.Outcome)
+	assert.True(t, result.Retryable)
+	assert.False(t, result.DeadLettered)
+	assert.Empty(t, h.reporter.leaks)
+	h.assertNotQuarantined(t, "config.txt", generation)
+}
+
+func TestProcessObjectDeadLettersPermanentScanErrors(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	objects := &failingStore{Memory: h.objects, err: errors.New("corrupt archive")}
+	p, err := NewPipelineWithStore(h.pipeline.cfg, objects, h.reporter)
+	require.NoError(t, err)
+	generation := h.upload("archive.zip", []byte("not really a zip"))
+
+	result, err := p.ProcessObject(context.Background(), objectRef("archive.zip", generation))
+
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeScanError, result.Outcome)
+	assert.False(t, result.Retryable)
+	assert.True(t, result.DeadLettered)
+	assert.True(t, result.Redaction.Quarantined)
+
+	require.Len(t, h.reporter.leaks, 1)
+	assert.Equal(t, scanner.ScanFailureType, h.reporter.leaks[0].Type)
+	assert.Contains(t, h.reporter.leaks[0].Data.Reason, "corrupt archive")
+	h.assertQuarantined(t, "archive.zip", generation, "not really a zip")
+}
+
+func TestProcessObjectReportFailures(t *testing.T) {
+	reportErr := errors.New("splunk is down")
+	tests := []struct {
+		name          string
+		redactor      *config.Redactor
+		retryFailures bool
+		outcome       Outcome
+		retryable     bool
+	}{
+		{
+			name:          "Retried",
+			redactor:      &config.Redactor{},
+			retryFailures: true,
+			outcome:       OutcomeLeaksReported,
+			retryable:     true,
+		},
+		{
+			name:     "RetriesDisabled",
+			redactor: &config.Redactor{},
+			outcome:  OutcomeLeaksReported,
+		},
+		{
+			name:          "Redacted",
+			redactor:      quarantineRedactor(),
+			retryFailures: true,
+			outcome:       OutcomeRedacted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, tt.redactor)
+			h.reporter.err = reportErr
+			h.pipeline.cfg.Reporter.RetryFailures = tt.retryFailures
+			generation := h.upload("config.txt", []byte(testSecret))
+
+			result, err := h.pipeline.ProcessObject(context.Background(), objectRef("config.txt", generation))
+
+			assert.Equal(t, tt.outcome, result.Outcome)
+			assert.ErrorIs(t, result.ReportErr, reportErr)
+			assert.Equal(t, tt.retryable, result.Retryable)
+			require.Len(t, h.reporter.leaks, 1)
+			if tt.retryable {
+				assert.ErrorIs(t, err, ErrRetryable)
+				assert.ErrorIs(t, err, reportErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAnalyzeObjectRedeliversUnreportedLeaks(t *testing.T) {
+	h := newHarness(t, &config.Redactor{})
+	h.pipeline.cfg.Dedup = &config.Dedup{Kind: "GCS", BucketName: "dedup", Prefix: "processed/"}
+	h.pipeline.cfg.Reporter.RetryFailures = true
+	p, err := NewPipelineWithStore(h.pipeline.cfg, h.objects, h.reporter)
+	require.NoError(t, err)
+
+	generation := h.upload("config.txt", []byte(testSecret))
+	ctx := context.Background()
+
+	// The event isn't marked as processed until the leaks are reported
+	h.reporter.err = errors.New("splunk is down")
+	require.ErrorIs(t, p.AnalyzeObject(ctx, finalizedEvent(t, testBucketName, "config.txt", generation)), ErrRetryable)
+
+	h.reporter.err = nil
+	require.NoError(t, p.AnalyzeObject(ctx, finalizedEvent(t, testBucketName, "config.txt", generation)))
+	require.NoError(t, p.AnalyzeObject(ctx, finalizedEvent(t, testBucketName, "config.txt", generation)))
+	assert.Len(t, h.reporter.leaks, 2)
+}
+
+func TestAnalyzeObjectSkipsDuplicates(t *testing.T) {
+	h := newHarness(t, &config.Redactor{Enabled: false, Mode: config.RedactorModeNotice})
+	h.pipeline.cfg.Dedup = &config.Dedup{Kind: "GCS", BucketName: "dedup", Prefix: "processed/"}
+	p, err := NewPipelineWithStore(h.pipeline.cfg, h.objects, h.reporter)
+	require.NoError(t, err)
+
+	generation := h.upload("config.txt", []byte(testSecret))
+	ctx := context.Background()
+
+	// The same event delivered twice
+	require.NoError(t, p.AnalyzeObject(ctx, finalizedEvent(t, testBucketName, "config.txt", generation)))
+	require.NoError(t, p.AnalyzeObject(ctx, finalizedEvent(t, testBucketName, "config.txt", generation)))
+	assert.Len(t, h.reporter.leaks, 1)
+
+	// A different event for the same generation
+	e := finalizedEvent(t, testBucketName, "config.txt", generation)
+	e.SetID("another-event")
+	require.NoError(t, p.AnalyzeObject(ctx, e))
+	assert.Len(t, h.reporter.leaks, 1)
+
+	result, err := p.ProcessObject(ctx, objectRef("config.txt", generation))
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeDuplicate, result.Outcome)
+
+	// A new generation is still scanned
+	generation = h.upload("config.txt", []byte(testSecret))
+	e = finalizedEvent(t, testBucketName, "config.txt", generation)
+	e.SetID("new-generation-event")
+	require.NoError(t, p.AnalyzeObject(ctx, e))
+	assert.Len(t, h.reporter.leaks, 2)
+}
+
+func TestAnalyzeObjectSkipsDedupMarkers(t *testing.T) {
+	h := newHarness(t, &config.Redactor{})
+	h.pipeline.cfg.Dedup = &config.Dedup{Kind: "GCS", BucketName: testBucketName, Prefix: "processed/"}
+	p, err := NewPipelineWithStore(h.pipeline.cfg, h.objects, h.reporter)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	generation := h.upload("processed/marker", []byte(testSecret))
+	e := finalizedEvent(t, testBucketName, "processed/marker", generation)
+	require.NoError(t, p.AnalyzeObject(ctx, e))
+	assert.Empty(t, h.reporter.leaks)
+
+	// Nothing was marked, so no new marker events are triggered
+	var markers []string
+	require.NoError(t, h.objects.List(ctx, testBucketName, "processed/", "", func(attrs *store.ObjectAttrs) error {
+		markers = append(markers, attrs.Name)
+		return nil
+	}))
+	assert.Equal(t, []string{"processed/marker"}, markers)
+
+	result, err := p.ProcessObject(ctx, objectRef("processed/marker", generation))
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeSkipped, result.Outcome)
+}
+
+func TestProcessObjectGates(t *testing.T) {
+	tests := []struct {
+		name    string
+		gates   *config.Gates
+		object  *store.ObjectAttrs
+		reason  string
+		scanned bool
+	}{
+		{
+			name:   "too large",
+			gates:  &config.Gates{MaxObjectSize: 10},
+			object: &store.ObjectAttrs{Name: "big.txt", Size: 11},
+			reason: "object size 11 exceeds the max object size 10",
+		},
+		{
+			name:    "small enough",
+			gates:   &config.Gates{MaxObjectSize: 10},
+			object:  &store.ObjectAttrs{Name: "small.txt", Size: 10},
+			scanned: true,
+		},
+		{
+			name:   "denied content type",
+			gates:  &config.Gates{ContentTypeDeny: []string{"video/*"}},
+			object: &store.ObjectAttrs{Name: "movie", ContentType: "video/mp4"},
+			reason: `content type "video/mp4" is denied`,
+		},
+		{
+			name:   "content type not allowed",
+			gates:  &config.Gates{ContentTypeAllow: []string{"text/*", "application/json"}},
+			object: &store.ObjectAttrs{Name: "image", ContentType: "image/png"},
+			reason: `content type "image/png" is not allowed`,
+		},
+		{
+			name:    "allowed content type with parameters",
+			gates:   &config.Gates{ContentTypeAllow: []string{"text/*"}},
+			object:  &store.ObjectAttrs{Name: "notes", ContentType: "Text/Plain; charset=utf-8"},
+			scanned: true,
+		},
+		{
+			name:   "denied extension",
+			gates:  &config.Gates{ExtensionDeny: []string{".mp4"}},
+			object: &store.ObjectAttrs{Name: "videos/movie.MP4"},
+			reason: `extension ".mp4" is denied`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, quarantineRedactor())
+			h.pipeline.cfg.Gates = tt.gates
+
+			object := tt.object
+			object.Bucket = testBucketName
+			object.Generation = h.upload(object.Name, []byte("clean\n"))
+
+			result, err := h.pipeline.ProcessObject(context.Background(), object)
+			require.NoError(t, err)
+
+			if tt.scanned {
+				assert.Equal(t, OutcomeClean, result.Outcome)
+				assert.Empty(t, h.reporter.leaks)
+				return
+			}
+
+			assert.Equal(t, OutcomeNotScanned, result.Outcome)
+			assert.Equal(t, tt.reason, result.NotScannedReason)
+			require.Len(t, h.reporter.leaks, 1)
+			assert.Equal(t, scanner.NotScannedType, h.reporter.leaks[0].Type)
+			assert.Equal(t, tt.reason, h.reporter.leaks[0].Data.Reason)
+		})
+	}
+}
+
+func TestAnalyzeObjectPolicies(t *testing.T) {
+	policies := []*config.Policy{
+		{Name: "reports", Prefix: "reports/", Action: config.PolicyActionReport, ReporterKinds: []string{"BigQuery"}},
+		{Name: "exports", Glob: "exports/*.csv", Action: config.PolicyActionQuarantine},
+		{Name: "fixtures", Bucket: "upl*", Prefix: "fixtures/", AllowlistPaths: []string{`\.golden$`}},
+		{Name: "other-bucket", Bucket: "other", Action: config.PolicyActionReport},
+	}
+
+	tests := []struct {
+		objectName  string
+		policy      string
+		outcome     Outcome
+		quarantined bool
+		kinds       []string
+	}{
+		{objectName: "reports/config.txt", policy: "reports", outcome: OutcomeLeaksReported, kinds: []string{"BigQuery"}},
+		{objectName: "exports/users.csv", policy: "exports", outcome: OutcomeRedacted, quarantined: true},
+		{objectName: "exports/nested/users.csv", outcome: OutcomeRedacted},
+		{objectName: "fixtures/config.golden", policy: "fixtures", outcome: OutcomeSkipped},
+		{objectName: "fixtures/config.txt", policy: "fixtures", outcome: OutcomeRedacted},
+		{objectName: "config.txt", outcome: OutcomeRedacted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.objectName, func(t *testing.T) {
+			h := newHarness(t, &config.Redactor{
+				Enabled:              true,
+				Mode:                 config.RedactorModeNotice,
+				QuarantineBucketName: testQuarantineBucket,
+			})
+			h.pipeline.cfg.Policies = policies
+			generation := h.upload(tt.objectName, []byte("token = "+testSecret+"\n"))
+
+			result, err := h.pipeline.ProcessObject(context.Background(), objectRef(tt.objectName, generation))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.policy, result.Policy)
+			assert.Equal(t, tt.outcome, result.Outcome)
+			assert.Equal(t, tt.quarantined, result.Redaction.Quarantined)
+			assert.Equal(t, tt.kinds, h.reporter.kinds)
+		})
+	}
+}
+
+func TestScanWouldRedact(t *testing.T) {
+	h := newHarness(t, &config.Redactor{Enabled: true, Mode: config.RedactorModeNotice})
+	h.pipeline.cfg.Policies = []*config.Policy{
+		{Name: "reports", Prefix: "reports/", Action: config.PolicyActionReport},
+	}
+
+	tests := []struct {
+		objectName  string
+		wouldRedact bool
+	}{
+		{objectName: "reports/config.txt", wouldRedact: false},
+		{objectName: "config.txt", wouldRedact: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.objectName, func(t *testing.T) {
+			generation := h.upload(tt.objectName, []byte("token = "+testSecret+"\n"))
+			attrs, err := h.objects.Attrs(context.Background(), testBucketName, tt.objectName, generation)
+			require.NoError(t, err)
+
+			result := Scan(context.Background(), h.pipeline.cfg, h.objects, attrs)
+			require.NoError(t, result.ScanErr)
+			assert.True(t, result.ShouldRedact())
+			assert.Equal(t, tt.wouldRedact, result.WouldRedact(h.pipeline.cfg.Redactor))
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		content := strings.NewReader("token = " + testSecret + "\n")
+		result := ScanReader(context.Background(), h.pipeline.cfg, "file:///tmp/config.txt", "config.txt", content)
+		require.NoError(t, result.ScanErr)
+		assert.True(t, result.WouldRedact(h.pipeline.cfg.Redactor))
+
+		assert.False(t, result.WouldRedact(&config.Redactor{}))
+	})
+}
+
+func TestAnalyzeObjectPolicyWithoutReporter(t *testing.T) {
+	h := newHarness(t, &config.Redactor{})
+	h.pipeline.cfg.Reporter.RetryFailures = true
+	h.pipeline.cfg.Policies = []*config.Policy{
+		{Name: "reports", Prefix: "reports/", Action: config.PolicyActionReport, ReporterKinds: []string{"Splunk"}},
+	}
+
+	// Only the Logger reporter is set up
+	router, err := reporter.NewRouter(context.Background(), h.pipeline.cfg.Reporter, []string{"Logger"})
+	require.NoError(t, err)
+	p, err := NewPipelineWithStore(h.pipeline.cfg, h.objects, router)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, router.Close())
+	})
+
+	generation := h.upload("reports/config.txt", []byte("token = "+testSecret+"\n"))
+	err = p.AnalyzeObject(context.Background(), finalizedEvent(t, testBucketName, "reports/config.txt", generation))
+	require.ErrorIs(t, err, ErrRetryable)
+	assert.ErrorIs(t, err, reporter.ErrNoReporter)
+
+	result, err := p.ProcessObject(context.Background(), objectRef("reports/config.txt", generation))
+	require.ErrorIs(t, err, reporter.ErrNoReporter)
+	assert.Equal(t, OutcomeLeaksReported, result.Outcome)
+	assert.True(t, result.Retryable)
+}
+
+func TestAnalyzeObjectEligibilityPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		eligibility []*config.EligibilityPolicy
+		content     string
+		outcome     Outcome
+		policy      string
+	}{
+		{
+			name:    "DefaultMatchesProductionSecrets",
+			content: testSecret,
+			outcome: OutcomeRedacted,
+			policy:  "production-secrets",
+		},
+		{
+			name: "RuleIDs",
+			eligibility: []*config.EligibilityPolicy{
+				{Name: "testing-rules", Rules: []string{"leaktk-testing-rule"}},
+			},
+			content: testTestingSecret,
+			outcome: OutcomeRedacted,
+			policy:  "testing-rules",
+		},
+		{
+			name: "ExcludedRule",
+			eligibility: []*config.EligibilityPolicy{
+				{Name: "secrets", IncludeTags: []string{"type:secret"}, ExcludeRules: []string{"leaktk-test-secret"}},
+			},
+			content: testSecret,
+			outcome: OutcomeLeaksReported,
+		},
+		{
+			name: "ExcludedTag",
+			eligibility: []*config.EligibilityPolicy{
+				{Name: "secrets", ExcludeTags: []string{"group:leaktk-testing"}},
+			},
+			content: testTestingSecret,
+			outcome: OutcomeLeaksReported,
+		},
+		{
+			name: "MinEntropy",
+			eligibility: []*config.EligibilityPolicy{
+				{Name: "high-entropy", IncludeTags: []string{"type:secret"}, MinEntropy: 10},
+			},
+			content: testSecret,
+			outcome: OutcomeLeaksReported,
+		},
+		{
+			name: "RuleMinEntropy",
+			eligibility: []*config.EligibilityPolicy{
+				{
+					Name:           "high-entropy",
+					IncludeTags:    []string{"type:secret"},
+					MinEntropy:     10,
+					RuleMinEntropy: map[string]float64{"leaktk-test-secret": 1},
+				},
+			},
+			content: testSecret,
+			outcome: OutcomeRedacted,
+			policy:  "high-entropy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, &config.Redactor{
+				Enabled:     true,
+				Mode:        config.RedactorModeNotice,
+				Eligibility: tt.eligibility,
+			})
+			generation := h.upload("config.txt", []byte("token = "+tt.content+"\n"))
+
+			result, err := h.pipeline.ProcessObject(context.Background(), objectRef("config.txt", generation))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.outcome, result.Outcome)
+			require.Len(t, h.reporter.leaks, 1)
+			assert.Equal(t, tt.policy, h.reporter.leaks[0].Data.RedactionPolicy)
+			assert.Equal(t, tt.policy, result.Decisions[0].Policy)
+		})
+	}
+}
+
+func TestAnalyzeObjectRedactionThresholds(t *testing.T) {
+	secondSecret := "leaktk_test_secret_fedcba9876543210"
+
+	tests := []struct {
+		name     string
+		redactor *config.Redactor
+		content  string
+		outcome  Outcome
+		severity string
+	}{
+		{
+			name:     "BelowMinLeakCount",
+			redactor: &config.Redactor{MinLeakCount: 2},
+			content:  testSecret,
+			outcome:  OutcomeLeaksReported,
+		},
+		{
+			name:     "MeetsMinLeakCount",
+			redactor: &config.Redactor{MinLeakCount: 2},
+			content:  testSecret + "\n" + secondSecret,
+			outcome:  OutcomeRedacted,
+		},
+		{
+			name:     "BelowMinEntropy",
+			redactor: &config.Redactor{MinEntropy: 10},
+			content:  testSecret,
+			outcome:  OutcomeLeaksReported,
+		},
+		{
+			name: "MeetsMinSeverity",
+			redactor: &config.Redactor{
+				Severities:  map[string]string{"type:secret": config.SeverityHigh},
+				MinSeverity: config.SeverityHigh,
+			},
+			content:  testSecret,
+			outcome:  OutcomeRedacted,
+			severity: config.SeverityHigh,
+		},
+		{
+			name: "BelowMinSeverity",
+			redactor: &config.Redactor{
+				Severities:  map[string]string{"type:secret": config.SeverityMedium},
+				MinSeverity: config.SeverityCritical,
+			},
+			content:  testSecret,
+			outcome:  OutcomeLeaksReported,
+			severity: config.SeverityMedium,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.redactor.Enabled = true
+			tt.redactor.Mode = config.RedactorModeNotice
+			h := newHarness(t, tt.redactor)
+			generation := h.upload("config.txt", []byte(tt.content+"\n"))
+
+			result, err := h.pipeline.ProcessObject(context.Background(), objectRef("config.txt", generation))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.outcome, result.Outcome)
+			require.NotEmpty(t, h.reporter.leaks)
+			for _, leak := range h.reporter.leaks {
+				assert.Equal(t, tt.severity, leak.Data.Severity)
+
+				if tt.outcome != OutcomeRedacted {
+					assert.Empty(t, leak.Data.RedactionPolicy, "leaks below the thresholds aren't marked for redaction")
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeObjectQuarantinesEachGeneration(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	acl := []store.ACLRule{{Entity: "user-owner@example.com", Role: "OWNER"}}
+	content := "token = " + testSecret + "\n"
+	generation := h.objects.Put(testBucketName, "config.txt", &store.ObjectAttrs{
+		ContentType: "text/x-config",
+		Metadata:    map[string]string{"owner": "test"},
+		ACL:         acl,
+	}, []byte(content))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	quarantineObjectName := redactor.QuarantineObjectName(testBucketName, "config.txt", generation)
+	quarantineURL := "gs://" + testQuarantineBucket + "/" + quarantineObjectName
+	h.assertQuarantined(t, "config.txt", generation, content)
+
+	require.Len(t, h.reporter.leaks, 1)
+	leak := h.reporter.leaks[0]
+	assert.Equal(t, quarantineURL, leak.Data.QuarantineURL)
+
+	attrs, err := h.objects.Attrs(context.Background(), testQuarantineBucket, quarantineObjectName, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "test", attrs.Metadata["owner"])
+	assert.Equal(t, testBucketName, attrs.Metadata[redactor.MetadataSourceBucket])
+	assert.Equal(t, "config.txt", attrs.Metadata[redactor.MetadataSourceObject])
+	assert.Equal(t, strconv.FormatInt(generation, 10), attrs.Metadata[redactor.MetadataSourceGeneration])
+	assert.Equal(t, "text/x-config", attrs.Metadata[redactor.MetadataContentType])
+	assert.Equal(t, `[{"entity":"user-owner@example.com","role":"OWNER"}]`, attrs.Metadata[redactor.MetadataACL])
+	assert.Equal(t, leak.ID, attrs.Metadata[redactor.MetadataLeakIDs])
+	assert.Equal(t, "1", attrs.Metadata[redactor.MetadataLeakCount])
+	assert.Equal(t, leak.Data.RuleID, attrs.Metadata[redactor.MetadataRules])
+	assert.NotEmpty(t, attrs.Metadata[redactor.MetadataQuarantinedAt])
+
+	// A new leak in a re-uploaded file gets its own quarantined copy
+	reuploaded := "token = " + testSecret + "\n# again\n"
+	nextGeneration := h.upload("config.txt", []byte(reuploaded))
+	require.NoError(t, h.analyze(t, "config.txt", nextGeneration))
+
+	h.assertQuarantined(t, "config.txt", generation, content)
+	h.assertQuarantined(t, "config.txt", nextGeneration, reuploaded)
+}
+
+func TestProcessObjectQuarantineIsIdempotent(t *testing.T) {
+	h := newHarness(t, quarantineRedactor())
+	generation := h.upload("archive.zip", []byte("not really a zip"))
+	objects := &failingStore{Memory: h.objects, err: errors.New("corrupt archive")}
+	p, err := NewPipelineWithStore(h.pipeline.cfg, objects, h.reporter)
+	require.NoError(t, err)
+
+	for range 2 {
+		result, err := p.ProcessObject(context.Background(), objectRef("archive.zip", generation))
+		require.NoError(t, err)
+		assert.True(t, result.Redaction.Quarantined)
+	}
+
+	attrs, err := h.objects.Attrs(context.Background(), testQuarantineBucket, redactor.QuarantineObjectName(testBucketName, "archive.zip", generation), 0)
+	require.NoError(t, err)
+	assert.Contains(t, attrs.Metadata[redactor.MetadataReason], "corrupt archive")
+}
+
+func TestRestoreQuarantinedObject(t *testing.T) {
+	redactorConfig := quarantineRedactor()
+	redactorConfig.QuarantineRetention = "720h"
+	h := newHarness(t, redactorConfig)
+	acl := []store.ACLRule{{Entity: "allUsers", Role: "READER"}}
+	content := "token = " + testSecret + "\n"
+	generation := h.objects.Put(testBucketName, "config.txt", &store.ObjectAttrs{
+		ContentType: "text/x-config",
+		Metadata:    map[string]string{"owner": "test"},
+		ACL:         acl,
+	}, []byte(content))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+	require.Equal(t, removedNotice, h.content(t, testBucketName, "config.txt"))
+
+	quarantineObjectName := redactor.QuarantineObjectName(testBucketName, "config.txt", generation)
+	attrs, err := h.objects.Attrs(context.Background(), testQuarantineBucket, quarantineObjectName, 0)
+	require.NoError(t, err)
+	quarantinedAt, err := time.Parse(time.RFC3339, attrs.Metadata[redactor.MetadataQuarantinedAt])
+	require.NoError(t, err)
+	retainUntil, err := time.Parse(time.RFC3339, attrs.Metadata[redactor.MetadataRetainUntil])
+	require.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, retainUntil.Sub(quarantinedAt))
+
+	restored, err := redactor.NewRedactor(redactorConfig, h.objects).Restore(context.Background(), quarantineObjectName)
+	require.NoError(t, err)
+
+	assert.Greater(t, restored.Generation, generation)
+	assert.Equal(t, content, h.content(t, testBucketName, "config.txt"))
+	assert.Equal(t, "text/x-config", restored.ContentType)
+	assert.Equal(t, map[string]string{"owner": "test", redactor.MetadataRestored: quarantineObjectName}, restored.Metadata)
+	assert.Equal(t, acl, restored.ACL)
+
+	// The finalize event for the restore doesn't redact it again
+	result, err := h.pipeline.ProcessObject(context.Background(), restored)
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeRestored, result.Outcome)
+	assert.Equal(t, content, h.content(t, testBucketName, "config.txt"))
+	assert.Len(t, h.reporter.leaks, 1)
+
+	// The flag isn't trusted if it doesn't name a copy of the object
+	generation = h.objects.Put(testBucketName, "other.txt", &store.ObjectAttrs{
+		Metadata: map[string]string{redactor.MetadataRestored: quarantineObjectName},
+	}, []byte(content))
+	result, err = h.pipeline.ProcessObject(context.Background(), &store.ObjectAttrs{
+		Bucket:     testBucketName,
+		Name:       "other.txt",
+		Generation: generation,
+		Metadata:   map[string]string{redactor.MetadataRestored: quarantineObjectName},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeRedacted, result.Outcome)
+
+	// Or if it's copied onto a new upload of the object
+	reuploaded := "token = leaktk_test_secret_fedcba9876543210\n"
+	generation = h.objects.Put(testBucketName, "config.txt", &store.ObjectAttrs{Metadata: restored.Metadata}, []byte(reuploaded))
+	result, err = h.pipeline.ProcessObject(context.Background(), &store.ObjectAttrs{
+		Bucket:     testBucketName,
+		Name:       "config.txt",
+		Generation: generation,
+		Metadata:   restored.Metadata,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeRedacted, result.Outcome)
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "config.txt"))
+
+	_, err = redactor.NewRedactor(redactorConfig, h.objects).Restore(context.Background(), "missing/object/1")
+	assert.ErrorIs(t, err, store.ErrNotExist)
+}
+
+func TestRestoreEncryptedQuarantinedObject(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "quarantine.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0o600))
+
+	redactorConfig := quarantineRedactor()
+	redactorConfig.QuarantineKeyFile = keyFile
+	h := newHarness(t, redactorConfig)
+	content := "token = " + testSecret + "\n"
+	generation := h.upload("config.txt", []byte(content))
+
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	quarantineObjectName := redactor.QuarantineObjectName(testBucketName, "config.txt", generation)
+	quarantined := h.content(t, testQuarantineBucket, quarantineObjectName)
+	assert.NotContains(t, quarantined, testSecret)
+
+	attrs, err := h.objects.Attrs(context.Background(), testQuarantineBucket, quarantineObjectName, 0)
+	require.NoError(t, err)
+	assert.Equal(t, redactor.EncryptionScheme, attrs.Metadata[redactor.MetadataEncryption])
+	assert.Equal(t, "file://"+keyFile, attrs.Metadata[redactor.MetadataKeyName])
+	assert.NotEmpty(t, attrs.Metadata[redactor.MetadataWrappedKey])
+
+	// Restoring without the key fails instead of restoring the ciphertext
+	_, err = redactor.NewRedactor(quarantineRedactor(), h.objects).Restore(context.Background(), quarantineObjectName)
+	assert.ErrorContains(t, err, "no quarantine key is configured")
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "config.txt"))
+
+	restored, err := redactor.NewRedactor(redactorConfig, h.objects).Restore(context.Background(), quarantineObjectName)
+	require.NoError(t, err)
+	assert.Equal(t, content, h.content(t, testBucketName, "config.txt"))
+	assert.Equal(t, "text/plain", restored.ContentType)
+	assert.Equal(t, map[string]string{"owner": "test", redactor.MetadataRestored: quarantineObjectName}, restored.Metadata)
+}
+
+func TestRestoreCorruptedQuarantinedObject(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "quarantine.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0o600))
+
+	redactorConfig := quarantineRedactor()
+	redactorConfig.QuarantineKeyFile = keyFile
+	h := newHarness(t, redactorConfig)
+
+	// Span several segments so that some plaintext is decrypted before the
+	// corrupted segment is reached
+	content := "token = " + testSecret + "\n" + strings.Repeat("filler\n", 30000)
+	generation := h.upload("config.txt", []byte(content))
+	require.NoError(t, h.analyze(t, "config.txt", generation))
+
+	quarantineObjectName := redactor.QuarantineObjectName(testBucketName, "config.txt", generation)
+	attrs, err := h.objects.Attrs(context.Background(), testQuarantineBucket, quarantineObjectName, 0)
+	require.NoError(t, err)
+	quarantined := []byte(h.content(t, testQuarantineBucket, quarantineObjectName))
+	quarantined[len(quarantined)-10] ^= 0xff
+	h.objects.Put(testQuarantineBucket, quarantineObjectName, attrs, quarantined)
+
+	before, err := h.objects.Attrs(context.Background(), testBucketName, "config.txt", 0)
+	require.NoError(t, err)
+
+	_, err = redactor.NewRedactor(redactorConfig, h.objects).Restore(context.Background(), quarantineObjectName)
+	require.Error(t, err)
+
+	after, err := h.objects.Attrs(context.Background(), testBucketName, "config.txt", 0)
+	require.NoError(t, err)
+	assert.Equal(t, before.Generation, after.Generation)
+	assert.Equal(t, removedNotice, h.content(t, testBucketName, "config.txt"))
+}
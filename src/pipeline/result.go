@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/perf"
+	"github.com/leaktk/gcs-filter/redactor"
+	"github.com/leaktk/gcs-filter/scanner"
+)
+
+// Outcome summarizes what happened to an object in the pipeline
+type Outcome string
+
+const (
+	// OutcomeClean means the object was scanned and no leaks were found
+	OutcomeClean Outcome = "clean"
+	// OutcomeLeaksReported means leaks were found and reported but the
+	// object wasn't redacted
+	OutcomeLeaksReported Outcome = "leaks_reported"
+	// OutcomeRedacted means leaks were found and reported and the object
+	// was redacted
+	OutcomeRedacted Outcome = "redacted"
+	// OutcomeSkipped means the object wasn't scanned because its path is
+	// allowlisted
+	OutcomeSkipped Outcome = "skipped"
+	// OutcomeSuperseded means a newer generation replaced the object before
+	// it could be scanned or redacted
+	OutcomeSuperseded Outcome = "superseded"
+	// OutcomeScanError means the scan failed before any leaks were found
+	OutcomeScanError Outcome = "scan_error"
+	// OutcomeNotScanned means the object wasn't scanned because it didn't
+	// pass the size, content type or extension gates
+	OutcomeNotScanned Outcome = "not_scanned"
+	// OutcomeDuplicate means the object generation was already processed
+	OutcomeDuplicate Outcome = "duplicate"
+	// OutcomeRestored means the object generation was put back from
+	// quarantine after a review and isn't scanned again
+	OutcomeRestored Outcome = "restored"
+)
+
+// RuleDecision records whether a leak puts the object in scope for
+// redaction
+type RuleDecision struct {
+	LeakID string `json:"leak_id"`
+	Rule   string `json:"rule"`
+	Redact bool   `json:"redact"`
+	// Policy is the eligibility policy that put the leak in scope
+	Policy string `json:"policy,omitempty"`
+}
+
+// ScanResult describes what the pipeline did with an object
+type ScanResult struct {
+	BucketName string          `json:"bucket_name"`
+	ObjectName string          `json:"object_name"`
+	Generation int64           `json:"generation"`
+	Outcome    Outcome         `json:"outcome"`
+	Leaks      []*scanner.Leak `json:"leaks"`
+	Decisions  []RuleDecision  `json:"decisions"`
+	Redaction  redactor.Result `json:"redaction"`
+	Timings    perf.Timings    `json:"timings"`
+	// ScanErr is set if the scan failed. Leaks found before the failure are
+	// still in Leaks.
+	ScanErr error `json:"-"`
+	// NotScannedReason explains which gate stopped the object from being
+	// scanned
+	NotScannedReason string `json:"not_scanned_reason,omitempty"`
+	// Retryable is true if the scan error is transient or the records
+	// couldn't be reported and the object should be processed again
+	Retryable bool `json:"retryable"`
+	// DeadLettered is true if a permanent scan error was reported
+	DeadLettered bool `json:"dead_lettered"`
+	// ReportErr is set if any of the records couldn't be reported
+	ReportErr error `json:"-"`
+	// Policy is the name of the policy that matched the object if any
+	Policy string `json:"policy,omitempty"`
+
+	policy *config.Policy
+}
+
+func newScanResult(bucketName, objectName string, generation int64) *ScanResult {
+	return &ScanResult{
+		BucketName: bucketName,
+		ObjectName: objectName,
+		Generation: generation,
+		Timings:    make(perf.Timings),
+	}
+}
+
+// RedactableLeaks returns the leaks that put the object in scope for
+// redaction
+func (r *ScanResult) RedactableLeaks() []*scanner.Leak {
+	var leaks []*scanner.Leak
+
+	for i, decision := range r.Decisions {
+		if decision.Redact {
+			leaks = append(leaks, r.Leaks[i])
+		}
+	}
+
+	return leaks
+}
+
+// ShouldRedact returns true if any leak puts the object in scope for
+// redaction
+func (r *ScanResult) ShouldRedact() bool {
+	for _, decision := range r.Decisions {
+		if decision.Redact {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WouldRedact returns true if the pipeline redacts the object: a leak puts
+// it in scope, redaction is enabled and its policy doesn't only report
+func (r *ScanResult) WouldRedact(rc *config.Redactor) bool {
+	if !r.ShouldRedact() {
+		return false
+	}
+
+	_, ok := RedactOptions(rc, r)
+	return ok
+}
+
+// RedactOptions returns the options to redact the object with and false if
+// the object shouldn't be redacted. The policy that matched the object (if
+// any) overrides the redactor config.
+func RedactOptions(rc *config.Redactor, result *ScanResult) (redactor.Options, bool) {
+	if !rc.Enabled {
+		return redactor.Options{}, false
+	}
+
+	defaults := redactor.Options{Quarantine: rc.Quarantine}
+	if result.policy == nil {
+		return defaults, true
+	}
+
+	switch result.policy.Action {
+	case config.PolicyActionReport:
+		logging.Info("skipping redaction: policy=%q object_name=\"%v\" generation=%d", result.Policy, result.ObjectName, result.Generation)
+		return redactor.Options{}, false
+	case config.PolicyActionRedact:
+		return redactor.Options{Quarantine: false}, true
+	case config.PolicyActionQuarantine:
+		return redactor.Options{Quarantine: true}, true
+	default:
+		return defaults, true
+	}
+}
+
+// record sets the leaks, redaction decisions and outcome of a scan
+func (r *ScanResult) record(rc *config.Redactor, leaks []*scanner.Leak, err error) {
+	logging.Info("scan details: leak_count=%d object_name=\"%v\"", len(leaks), r.ObjectName)
+	r.Leaks = leaks
+	r.Decisions = decide(rc, leaks)
+
+	switch {
+	case len(leaks) > 0:
+		r.Outcome = OutcomeLeaksReported
+	case err != nil:
+		r.Outcome = OutcomeScanError
+	default:
+		r.Outcome = OutcomeClean
+	}
+}
+
+// decide records the redaction decision for each leak
+func decide(rc *config.Redactor, leaks []*scanner.Leak) []RuleDecision {
+	redactable := make(map[*scanner.Leak]bool)
+	for _, leak := range redactor.RedactableLeaks(rc, leaks) {
+		redactable[leak] = true
+	}
+
+	decisions := make([]RuleDecision, len(leaks))
+	for i, leak := range leaks {
+		decisions[i] = RuleDecision{
+			LeakID: leak.ID,
+			Rule:   leak.Data.Rule,
+			Redact: redactable[leak],
+			Policy: leak.Data.RedactionPolicy,
+		}
+	}
+
+	return decisions
+}
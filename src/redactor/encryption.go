@@ -0,0 +1,190 @@
+package redactor
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EncryptionScheme describes how quarantined payloads are encrypted. It's
+// recorded in the object metadata so the format can change later.
+//
+// The payload starts with a random nonce prefix followed by the content split
+// into segments that are each sealed with AES-256-GCM using the data key. A
+// segment's nonce is the prefix, the segment number and a flag marking the
+// last segment so segments can't be reordered, dropped or truncated without
+// the decryption failing. This keeps large objects from being loaded into
+// memory to be encrypted.
+const EncryptionScheme = "aes256-gcm-stream-64k"
+
+const (
+	segmentSize     = 64 * 1024
+	noncePrefixSize = 7
+)
+
+// encryptWriter encrypts everything written to it to w. Close must be called
+// to write the last segment but it doesn't close w.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	wrote   bool
+}
+
+func newEncryptWriter(w io.Writer, dataKey []byte) (*encryptWriter, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("rand.Read: %w", err)
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+
+	return append(nonce, 0)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if !e.wrote {
+		if _, err := e.w.Write(e.prefix); err != nil {
+			return err
+		}
+
+		e.wrote = true
+	}
+
+	if e.counter == ^uint32(0) {
+		return errors.New("payload is too large to encrypt")
+	}
+
+	sealed := e.aead.Seal(nil, segmentNonce(e.prefix, e.counter, last), e.buf, nil)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// Write buffers p and seals each full segment. A full segment is only sealed
+// once more data arrives since the last segment is sealed differently.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == segmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := min(segmentSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close seals the last segment
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// decryptReader decrypts a payload written by encryptWriter
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	segment []byte
+	plain   []byte
+	done    bool
+}
+
+func newDecryptReader(r io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("could not read the payload header: %w", err)
+	}
+
+	return &decryptReader{
+		r:       bufio.NewReaderSize(r, segmentSize+aead.Overhead()+1),
+		aead:    aead,
+		prefix:  prefix,
+		segment: make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open decrypts the next segment. A segment is the last one if nothing
+// follows it.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.segment)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	last := err != nil
+	if !last {
+		if _, peekErr := d.r.Peek(1); errors.Is(peekErr, io.EOF) {
+			last = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	}
+
+	plain, err := d.aead.Open(d.segment[:0], segmentNonce(d.prefix, d.counter, last), d.segment[:n], nil)
+	if err != nil {
+		return fmt.Errorf("could not decrypt the payload: segment=%d: %w", d.counter, err)
+	}
+
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
@@ -0,0 +1,117 @@
+package redactor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+func encrypt(t *testing.T, dataKey, plaintext []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	encrypter, err := newEncryptWriter(&buf, dataKey)
+	require.NoError(t, err)
+
+	// Write in uneven chunks to cross the segment boundaries
+	for start := 0; start < len(plaintext); start += 10000 {
+		_, err = encrypter.Write(plaintext[start:min(start+10000, len(plaintext))])
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, encrypter.Close())
+	return buf.Bytes()
+}
+
+func decrypt(dataKey, ciphertext []byte) ([]byte, error) {
+	decrypter, err := newDecryptReader(bytes.NewReader(ciphertext), dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(decrypter)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	dataKey := randomBytes(t, dataKeySize)
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3 * segmentSize} {
+		plaintext := randomBytes(t, size)
+		ciphertext := encrypt(t, dataKey, plaintext)
+
+		decrypted, err := decrypt(dataKey, ciphertext)
+		require.NoError(t, err, "size=%d", size)
+		assert.True(t, bytes.Equal(plaintext, decrypted), "size=%d", size)
+	}
+}
+
+func TestEncryptionDetectsTampering(t *testing.T) {
+	dataKey := randomBytes(t, dataKeySize)
+	plaintext := randomBytes(t, 2*segmentSize+100)
+	ciphertext := encrypt(t, dataKey, plaintext)
+	sealedSegmentSize := segmentSize + 16
+	flipped := bytes.Clone(ciphertext)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := map[string]struct {
+		dataKey    []byte
+		ciphertext []byte
+	}{
+		"Truncated":       {dataKey, ciphertext[:noncePrefixSize+sealedSegmentSize]},
+		"DroppedSegment":  {dataKey, append(bytes.Clone(ciphertext[:noncePrefixSize+sealedSegmentSize]), ciphertext[noncePrefixSize+2*sealedSegmentSize:]...)},
+		"FlippedBit":      {dataKey, flipped},
+		"MissingContent":  {dataKey, ciphertext[:noncePrefixSize]},
+		"TruncatedHeader": {dataKey, ciphertext[:3]},
+		"WrongDataKey":    {randomBytes(t, dataKeySize), ciphertext},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decrypt(tt.dataKey, tt.ciphertext)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLocalKeyWrapper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quarantine.key")
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(randomBytes(t, dataKeySize))+"\n"), 0o600))
+
+	wrapper := &LocalKeyWrapper{Path: path}
+	dataKey := randomBytes(t, dataKeySize)
+
+	wrappedKey, err := wrapper.Wrap(context.Background(), dataKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, dataKey, wrappedKey)
+
+	unwrapped, err := wrapper.Unwrap(context.Background(), wrappedKey)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	otherPath := filepath.Join(t.TempDir(), "other.key")
+	require.NoError(t, os.WriteFile(otherPath, []byte(base64.StdEncoding.EncodeToString(randomBytes(t, dataKeySize))), 0o600))
+	_, err = (&LocalKeyWrapper{Path: otherPath}).Unwrap(context.Background(), wrappedKey)
+	assert.Error(t, err)
+
+	invalidPath := filepath.Join(t.TempDir(), "invalid.key")
+	require.NoError(t, os.WriteFile(invalidPath, []byte("too short"), 0o600))
+	_, err = (&LocalKeyWrapper{Path: invalidPath}).Wrap(context.Background(), dataKey)
+	assert.ErrorContains(t, err, "must contain a base64 encoded 32 byte key")
+}
@@ -0,0 +1,193 @@
+package redactor
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+
+	"github.com/leaktk/gcs-filter/config"
+)
+
+// dataKeySize is the size of the per-object AES-256 data keys
+const dataKeySize = 32
+
+// KeyWrapper encrypts (wraps) and decrypts (unwraps) the data keys used to
+// encrypt quarantined objects
+type KeyWrapper interface {
+	// Name identifies the key that wraps the data keys
+	Name() string
+	// Wrap encrypts a data key
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	// Unwrap decrypts a data key wrapped by Wrap
+	Unwrap(ctx context.Context, wrappedKey []byte) ([]byte, error)
+}
+
+// NewKeyWrapper returns the key wrapper set in the redactor config or nil if
+// quarantined objects shouldn't be encrypted
+func NewKeyWrapper(rc *config.Redactor) KeyWrapper {
+	switch {
+	case len(rc.QuarantineKMSKey) > 0:
+		return &KMSKeyWrapper{KeyName: rc.QuarantineKMSKey}
+	case len(rc.QuarantineKeyFile) > 0:
+		return &LocalKeyWrapper{Path: rc.QuarantineKeyFile}
+	default:
+		return nil
+	}
+}
+
+// LocalKeyWrapper wraps data keys with AES-GCM using a base64 encoded 256 bit
+// key read from a file. It's meant for tests and local runs. The file is only
+// read when the first key is wrapped or unwrapped.
+type LocalKeyWrapper struct {
+	Path string
+
+	once sync.Once
+	aead cipher.AEAD
+	err  error
+}
+
+// Name returns the path of the key file
+func (w *LocalKeyWrapper) Name() string {
+	return "file://" + w.Path
+}
+
+func (w *LocalKeyWrapper) load() (cipher.AEAD, error) {
+	w.once.Do(func() {
+		data, err := os.ReadFile(w.Path) // #nosec G304 -- the path comes from the operator
+		if err != nil {
+			w.err = fmt.Errorf("could not read the key file: %w", err)
+			return
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != dataKeySize {
+			w.err = fmt.Errorf("the key file must contain a base64 encoded %d byte key: path=%q", dataKeySize, w.Path)
+			return
+		}
+
+		w.aead, w.err = newGCM(key)
+	})
+
+	return w.aead, w.err
+}
+
+// Wrap encrypts the data key with the key from the file
+func (w *LocalKeyWrapper) Wrap(_ context.Context, dataKey []byte) ([]byte, error) {
+	aead, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("rand.Read: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// Unwrap decrypts a data key wrapped by Wrap
+func (w *LocalKeyWrapper) Unwrap(_ context.Context, wrappedKey []byte) ([]byte, error) {
+	aead, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+
+	nonce, ciphertext := wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap the data key: %w", err)
+	}
+
+	return dataKey, nil
+}
+
+// KMSKeyWrapper wraps data keys with a Google Cloud KMS symmetric key
+// (projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY). The
+// client is only created when the first key is wrapped or unwrapped.
+type KMSKeyWrapper struct {
+	KeyName string
+
+	once    sync.Once
+	service *cloudkms.Service
+	err     error
+}
+
+// Name returns the name of the KMS key
+func (w *KMSKeyWrapper) Name() string {
+	return w.KeyName
+}
+
+func (w *KMSKeyWrapper) keys(ctx context.Context) (*cloudkms.ProjectsLocationsKeyRingsCryptoKeysService, error) {
+	w.once.Do(func() {
+		w.service, w.err = cloudkms.NewService(ctx)
+	})
+
+	if w.err != nil {
+		return nil, fmt.Errorf("cloudkms.NewService: %w", w.err)
+	}
+
+	return w.service.Projects.Locations.KeyRings.CryptoKeys, nil
+}
+
+// Wrap encrypts the data key with the KMS key
+func (w *KMSKeyWrapper) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	keys, err := w.keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := keys.Encrypt(w.KeyName, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(dataKey),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("could not wrap the data key: key_name=%q: %w", w.KeyName, err)
+	}
+
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+// Unwrap decrypts a data key wrapped by Wrap. KMS picks the key version that
+// wrapped it so keys can be rotated.
+func (w *KMSKeyWrapper) Unwrap(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	keys, err := w.keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := keys.Decrypt(w.KeyName, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(wrappedKey),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap the data key: key_name=%q: %w", w.KeyName, err)
+	}
+
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,459 @@
+package redactor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/scanner"
+	"github.com/leaktk/gcs-filter/store"
+)
+
+// Metadata keys set on quarantined objects. The object's own metadata is kept
+// alongside them.
+const (
+	MetadataSourceBucket     = "leaktk-source-bucket"
+	MetadataSourceObject     = "leaktk-source-object"
+	MetadataSourceGeneration = "leaktk-source-generation"
+	MetadataSourceUpdated    = "leaktk-source-updated"
+	MetadataContentType      = "leaktk-content-type"
+	MetadataContentEncoding  = "leaktk-content-encoding"
+	MetadataACL              = "leaktk-acl"
+	MetadataLeakIDs          = "leaktk-leak-ids"
+	MetadataLeakCount        = "leaktk-leak-count"
+	MetadataRules            = "leaktk-rules"
+	MetadataReason           = "leaktk-reason"
+	MetadataQuarantinedAt    = "leaktk-quarantined-at"
+	MetadataRetainUntil      = "leaktk-retain-until"
+	MetadataEncryption       = "leaktk-encryption"
+	MetadataWrappedKey       = "leaktk-wrapped-key"
+	MetadataKeyName          = "leaktk-key-name"
+)
+
+// MetadataRestored is set on restored objects to the name of the quarantined
+// object they were restored from so that the finalize event for the restore
+// isn't redacted again
+const MetadataRestored = "leaktk-restored"
+
+// MetadataRestoredCRC32C is set on the quarantined object to the checksum of
+// the content it was restored with. The restored flag is only trusted on an
+// object with that content, so it can't be copied onto another upload.
+const MetadataRestoredCRC32C = "leaktk-restored-crc32c"
+
+// quarantineMetadataKeys are removed from the metadata when an object is
+// restored
+var quarantineMetadataKeys = []string{
+	MetadataSourceBucket,
+	MetadataSourceObject,
+	MetadataSourceGeneration,
+	MetadataSourceUpdated,
+	MetadataContentType,
+	MetadataContentEncoding,
+	MetadataACL,
+	MetadataLeakIDs,
+	MetadataLeakCount,
+	MetadataRules,
+	MetadataReason,
+	MetadataQuarantinedAt,
+	MetadataRetainUntil,
+	MetadataEncryption,
+	MetadataWrappedKey,
+	MetadataKeyName,
+	MetadataRestored,
+	MetadataRestoredCRC32C,
+}
+
+// maxMetadataLeakIDs keeps the metadata under the size limits for objects
+// with a lot of leaks. The full list is in the leak reports.
+const maxMetadataLeakIDs = 100
+
+// QuarantineObjectName returns the name a generation of an object is stored
+// under in the quarantine bucket so that every generation gets its own copy
+func QuarantineObjectName(bucketName, objectName string, generation int64) string {
+	return bucketName + "/" + objectName + "/" + strconv.FormatInt(generation, 10)
+}
+
+func quarantineMetadata(attrs *store.ObjectAttrs, leaks []*scanner.Leak, reason string, quarantinedAt time.Time, retention time.Duration) (map[string]string, error) {
+	metadata := maps.Clone(attrs.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+
+	metadata[MetadataSourceBucket] = attrs.Bucket
+	metadata[MetadataSourceObject] = attrs.Name
+	metadata[MetadataSourceGeneration] = strconv.FormatInt(attrs.Generation, 10)
+	metadata[MetadataContentType] = attrs.ContentType
+	metadata[MetadataContentEncoding] = attrs.ContentEncoding
+	metadata[MetadataQuarantinedAt] = quarantinedAt.UTC().Format(time.RFC3339)
+
+	if !attrs.Updated.IsZero() {
+		metadata[MetadataSourceUpdated] = attrs.Updated.UTC().Format(time.RFC3339)
+	}
+
+	if retention > 0 {
+		metadata[MetadataRetainUntil] = quarantinedAt.Add(retention).UTC().Format(time.RFC3339)
+	}
+
+	if len(attrs.ACL) > 0 {
+		acl, err := json.Marshal(attrs.ACL)
+		if err != nil {
+			return nil, fmt.Errorf("json.Marshal(acl): %w", err)
+		}
+
+		metadata[MetadataACL] = string(acl)
+	}
+
+	if len(leaks) > 0 {
+		var ids, rules []string
+		for _, leak := range leaks {
+			if len(ids) < maxMetadataLeakIDs {
+				ids = append(ids, leak.ID)
+			}
+
+			if !slices.Contains(rules, leak.Data.RuleID) {
+				rules = append(rules, leak.Data.RuleID)
+			}
+		}
+
+		metadata[MetadataLeakIDs] = strings.Join(ids, ",")
+		metadata[MetadataLeakCount] = strconv.Itoa(len(leaks))
+		metadata[MetadataRules] = strings.Join(rules, ",")
+	}
+
+	if len(reason) > 0 {
+		metadata[MetadataReason] = reason
+	}
+
+	return metadata, nil
+}
+
+// Quarantine copies a generation of an object to the quarantine bucket
+// without redacting it (e.g. when it couldn't be scanned) and returns the
+// gs:// URL of the copy. The reason is recorded in the quarantined object's
+// metadata.
+func (r *Redactor) Quarantine(ctx context.Context, bucketName, objectName string, generation int64, reason string) (string, error) {
+	if len(r.quarantineBucketName) == 0 {
+		return "", errors.New("quarantine called without a quarantine bucket")
+	}
+
+	// The copy is bounded by the caller's context and not a fixed timeout
+	// since large (and encrypted) copies can take a while
+	quarantineObjectName, err := r.copyToQuarantineBucket(ctx, bucketName, objectName, generation, nil, reason)
+	if err != nil {
+		return "", err
+	}
+
+	return r.quarantineURL(quarantineObjectName), nil
+}
+
+func (r *Redactor) quarantineURL(quarantineObjectName string) string {
+	return "gs://" + r.quarantineBucketName + "/" + quarantineObjectName
+}
+
+// copyToQuarantineBucket copies the generation of the object to its own path
+// in the quarantine bucket with metadata linking it to the leaks and
+// recording what's needed to restore it. It returns the name of the copy.
+func (r *Redactor) copyToQuarantineBucket(ctx context.Context, bucketName, objectName string, generation int64, leaks []*scanner.Leak, reason string) (string, error) {
+	logging.Info("quarantining object: object_name=\"%v\" generation=%d", objectName, generation)
+	quarantineObjectName := QuarantineObjectName(bucketName, objectName, generation)
+
+	attrs, err := r.objects.Attrs(ctx, bucketName, objectName, generation)
+	if err != nil {
+		return "", supersededOr(fmt.Errorf("could not copy %q: %w", objectName, err))
+	}
+
+	metadata, err := quarantineMetadata(attrs, leaks, reason, time.Now(), r.quarantineRetention)
+	if err != nil {
+		return "", err
+	}
+
+	if r.keys != nil {
+		err = r.encryptToQuarantineBucket(ctx, attrs, quarantineObjectName, metadata)
+	} else {
+		err = r.objects.Copy(ctx, bucketName, objectName, r.quarantineBucketName, quarantineObjectName, store.CopyOptions{
+			// Only copy the generation that was scanned
+			SrcConditions: store.Conditions{GenerationMatch: generation},
+			// Don't write to the object if it already exists
+			DstConditions: store.Conditions{DoesNotExist: true},
+			Metadata:      metadata,
+		})
+	}
+
+	if err != nil {
+		// The destination condition can also fail so check if this
+		// generation was already quarantined (e.g. the event was redelivered)
+		// before checking if the source changed
+		if isSuperseded(err) {
+			if _, attrsErr := r.objects.Attrs(ctx, r.quarantineBucketName, quarantineObjectName, 0); attrsErr == nil {
+				logging.Info("object already quarantined: object_name=\"%v\" generation=%d", objectName, generation)
+				return quarantineObjectName, nil
+			}
+
+			return "", fmt.Errorf("%w: could not copy %q: %w", ErrSuperseded, objectName, err)
+		}
+
+		return "", fmt.Errorf("could not copy %q: %w", objectName, err)
+	}
+
+	logging.Info("object quarantined: object_name=\"%v\" quarantine_object_name=\"%v\"", objectName, quarantineObjectName)
+	return quarantineObjectName, nil
+}
+
+// encryptToQuarantineBucket writes the generation of the object to the
+// quarantine bucket encrypted with a new data key. The data key is wrapped
+// with the configured key and stored in the metadata.
+func (r *Redactor) encryptToQuarantineBucket(ctx context.Context, attrs *store.ObjectAttrs, quarantineObjectName string, metadata map[string]string) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("rand.Read: %w", err)
+	}
+
+	wrappedKey, err := r.keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return err
+	}
+
+	metadata[MetadataEncryption] = EncryptionScheme
+	metadata[MetadataWrappedKey] = base64.StdEncoding.EncodeToString(wrappedKey)
+	metadata[MetadataKeyName] = r.keys.Name()
+
+	reader, err := r.objects.NewReader(ctx, attrs.Bucket, attrs.Name, attrs.Generation)
+	if err != nil {
+		return fmt.Errorf("objects.NewReader: %w", err)
+	}
+
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	// Cancel the write on errors so that a partial copy isn't left behind
+	// and mistaken for a complete one when the event is retried
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer := r.objects.NewWriter(writeCtx, r.quarantineBucketName, quarantineObjectName, &store.ObjectAttrs{
+		ContentType: "application/octet-stream",
+		Metadata:    metadata,
+	}, store.Conditions{DoesNotExist: true})
+
+	encrypter, err := newEncryptWriter(writer, dataKey)
+	if err != nil {
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+
+	// Close not deferred because we want to know if it errors out after
+	// a successful write
+	if _, err := io.Copy(encrypter, reader); err != nil {
+		cancel()
+		_ = writer.Close()
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+
+	if err := encrypter.Close(); err != nil {
+		cancel()
+		_ = writer.Close()
+		return fmt.Errorf("encrypter.Close: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("writer.Close: %w", err)
+	}
+
+	return nil
+}
+
+// decryptReader returns a reader for the plaintext of a quarantined object
+// if it was encrypted
+func (r *Redactor) decryptReader(ctx context.Context, attrs *store.ObjectAttrs, reader io.Reader) (io.Reader, error) {
+	scheme, encrypted := attrs.Metadata[MetadataEncryption]
+	if !encrypted {
+		return reader, nil
+	}
+
+	if scheme != EncryptionScheme {
+		return nil, fmt.Errorf("unsupported encryption scheme: %q", scheme)
+	}
+
+	if r.keys == nil {
+		return nil, fmt.Errorf("the object is encrypted but no quarantine key is configured: key_name=%q", attrs.Metadata[MetadataKeyName])
+	}
+
+	if keyName := attrs.Metadata[MetadataKeyName]; keyName != r.keys.Name() {
+		return nil, fmt.Errorf("the object was encrypted with a different key: key_name=%q configured_key_name=%q", keyName, r.keys.Name())
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(attrs.Metadata[MetadataWrappedKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s metadata: %w", MetadataWrappedKey, err)
+	}
+
+	dataKey, err := r.keys.Unwrap(ctx, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDecryptReader(reader, dataKey)
+}
+
+// Restore writes a quarantined object back to where it was quarantined from
+// with its original content type, metadata and ACL (e.g. after a leak was
+// reviewed and found to be a false positive). It replaces whatever is there
+// now and returns the attributes of the restored object.
+func (r *Redactor) Restore(ctx context.Context, quarantineObjectName string) (*store.ObjectAttrs, error) {
+	if len(r.quarantineBucketName) == 0 {
+		return nil, errors.New("restore called without a quarantine bucket")
+	}
+
+	attrs, err := r.objects.Attrs(ctx, r.quarantineBucketName, quarantineObjectName, 0)
+	if err != nil {
+		return nil, fmt.Errorf("objects.Attrs: %w", err)
+	}
+
+	bucketName := attrs.Metadata[MetadataSourceBucket]
+	objectName := attrs.Metadata[MetadataSourceObject]
+	if len(bucketName) == 0 || len(objectName) == 0 {
+		return nil, fmt.Errorf("not a quarantined object: %q", quarantineObjectName)
+	}
+
+	restoredAttrs := &store.ObjectAttrs{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.Metadata[MetadataContentEncoding],
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.Metadata[MetadataContentType],
+		Metadata:           maps.Clone(attrs.Metadata),
+	}
+
+	for _, key := range quarantineMetadataKeys {
+		delete(restoredAttrs.Metadata, key)
+	}
+
+	if restoredAttrs.Metadata == nil {
+		restoredAttrs.Metadata = make(map[string]string, 1)
+	}
+
+	restoredAttrs.Metadata[MetadataRestored] = quarantineObjectName
+	if rawACL := attrs.Metadata[MetadataACL]; len(rawACL) > 0 {
+		if err := json.Unmarshal([]byte(rawACL), &restoredAttrs.ACL); err != nil {
+			return nil, fmt.Errorf("invalid %s metadata: %w", MetadataACL, err)
+		}
+	}
+
+	// Only replace the generation that was checked so a concurrent upload
+	// isn't clobbered
+	conds := store.Conditions{DoesNotExist: true}
+	current, err := r.objects.Attrs(ctx, bucketName, objectName, 0)
+	if err == nil {
+		conds = store.Conditions{GenerationMatch: current.Generation}
+	} else if !errors.Is(err, store.ErrNotExist) {
+		return nil, fmt.Errorf("objects.Attrs: %w", err)
+	}
+
+	reader, err := r.objects.NewReader(ctx, r.quarantineBucketName, quarantineObjectName, attrs.Generation)
+	if err != nil {
+		return nil, fmt.Errorf("objects.NewReader: %w", err)
+	}
+
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	content, err := r.decryptReader(ctx, attrs, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Info("restoring object: object_name=\"%v\" quarantine_object_name=\"%v\"", objectName, quarantineObjectName)
+
+	// Cancel the write on errors so that truncated or partially decrypted
+	// content doesn't replace the object
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer := r.objects.NewWriter(writeCtx, bucketName, objectName, restoredAttrs, conds)
+
+	// Close not deferred because we want to know if it errors out after
+	// a successful write
+	checksum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(io.MultiWriter(writer, checksum), content); err != nil {
+		cancel()
+		_ = writer.Close()
+		return nil, fmt.Errorf("io.Copy: %w", err)
+	}
+
+	// Record the checksum before the object is written so that it's there
+	// when the finalize event for the restore is handled
+	restoredCRC32C := map[string]string{MetadataRestoredCRC32C: strconv.FormatUint(uint64(checksum.Sum32()), 10)}
+	if err := r.objects.UpdateMetadata(ctx, r.quarantineBucketName, quarantineObjectName, restoredCRC32C, store.Conditions{GenerationMatch: attrs.Generation}); err != nil {
+		cancel()
+		_ = writer.Close()
+		return nil, fmt.Errorf("objects.UpdateMetadata: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("writer.Close: %w", err)
+	}
+
+	restored, err := r.objects.Attrs(ctx, bucketName, objectName, 0)
+	if err != nil {
+		return nil, fmt.Errorf("objects.Attrs: %w", err)
+	}
+
+	logging.Info("object restored: object_name=\"%v\" generation=%d", objectName, restored.Generation)
+	return restored, nil
+}
+
+// Restored returns true if the object was put back by Restore. The flag is
+// only trusted if the quarantined object it names exists, was quarantined
+// from the same bucket and object and was restored with the same content as
+// this generation, so it can't be set or copied onto an upload to skip the
+// scan.
+func (r *Redactor) Restored(ctx context.Context, object *store.ObjectAttrs) bool {
+	quarantineObjectName := object.Metadata[MetadataRestored]
+	if len(quarantineObjectName) == 0 {
+		return false
+	}
+
+	if len(r.quarantineBucketName) == 0 {
+		logging.Warning("ignoring restored flag without a quarantine bucket: object_name=\"%v\" generation=%d", object.Name, object.Generation)
+		return false
+	}
+
+	attrs, err := r.objects.Attrs(ctx, r.quarantineBucketName, quarantineObjectName, 0)
+	if err != nil {
+		logging.Warning("ignoring restored flag: object_name=\"%v\" generation=%d quarantine_object_name=\"%v\" err=%q", object.Name, object.Generation, quarantineObjectName, err)
+		return false
+	}
+
+	if attrs.Metadata[MetadataSourceBucket] != object.Bucket || attrs.Metadata[MetadataSourceObject] != object.Name {
+		logging.Warning("ignoring restored flag for another object: object_name=\"%v\" generation=%d quarantine_object_name=\"%v\"", object.Name, object.Generation, quarantineObjectName)
+		return false
+	}
+
+	// The event doesn't carry the checksum so look up the generation's
+	current, err := r.objects.Attrs(ctx, object.Bucket, object.Name, object.Generation)
+	if err != nil {
+		logging.Warning("ignoring restored flag: object_name=\"%v\" generation=%d err=%q", object.Name, object.Generation, err)
+		return false
+	}
+
+	if attrs.Metadata[MetadataRestoredCRC32C] != strconv.FormatUint(uint64(current.CRC32C), 10) {
+		logging.Warning("ignoring restored flag for changed content: object_name=\"%v\" generation=%d quarantine_object_name=\"%v\"", object.Name, object.Generation, quarantineObjectName)
+		return false
+	}
+
+	return true
+}
@@ -1,88 +1,478 @@
 package redactor
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
 	"time"
-
-	"cloud.google.com/go/storage"
+	"unicode/utf8"
 
 	"github.com/leaktk/gcs-filter/config"
 	"github.com/leaktk/gcs-filter/logging"
 	"github.com/leaktk/gcs-filter/perf"
+	"github.com/leaktk/gcs-filter/scanner"
+	"github.com/leaktk/gcs-filter/store"
 )
 
-const notice = "This file contained potentially sensitive information and has been removed.\n"
+// maxMaskSize limits how much of an object will be loaded into memory to be
+// masked. Anything larger than this falls back to full removal.
+const maxMaskSize = 32 * 1024 * 1024
+
+// ErrSuperseded is returned when the object was replaced by a newer
+// generation before it could be redacted
+var ErrSuperseded = errors.New("object superseded by a newer generation")
+
+// Result describes what the redactor did to an object
+type Result struct {
+	// Mode is the redactor mode that was applied to the object. It can
+	// differ from the configured mode if masking fell back to a notice.
+	Mode string
+	// Quarantined is true if the object was copied to the quarantine bucket
+	Quarantined bool
+	// QuarantineURL is the gs:// URL of the quarantined copy
+	QuarantineURL string
+	// Masked is true if only the offending secrets were replaced
+	Masked bool
+	// Removed is true if the full content of the object was replaced
+	Removed bool
+	// Deleted is true if the object was deleted
+	Deleted bool
+}
+
+// Options override the redactor config for a single object (e.g. from a
+// policy)
+type Options struct {
+	// Quarantine copies the object to the quarantine bucket before it's
+	// redacted
+	Quarantine bool
+}
 
 // Redactor removes objects from the bucket and optionally quarantines them
 type Redactor struct {
-	Enabled          bool
-	quarantine       bool
-	quarantineBucket *storage.BucketHandle
+	Enabled              bool
+	mode                 string
+	notice               *template.Template
+	contactURL           string
+	objects              store.ObjectStore
+	quarantine           bool
+	quarantineBucketName string
+	quarantineRetention  time.Duration
+	keys                 KeyWrapper
 }
 
 // NewRedactor returns a configured pointer to a Redactor struct
-func NewRedactor(rc *config.Redactor, storageClient *storage.Client) *Redactor {
+func NewRedactor(rc *config.Redactor, objects store.ObjectStore) *Redactor {
+	// The retention and notice are checked when the config is loaded, so an
+	// invalid notice here means the config was built some other way
+	retention, _ := time.ParseDuration(rc.QuarantineRetention)
+	notice, err := rc.NoticeTemplate()
+	if err != nil {
+		logging.Error("invalid notice template, using the default one: %w", err)
+		notice = template.Must(template.New("notice").Parse(config.DefaultNoticeTemplate))
+	}
+
 	return &Redactor{
-		Enabled:          rc.Enabled,
-		quarantine:       rc.Quarantine,
-		quarantineBucket: storageClient.Bucket(rc.QuarantineBucketName),
+		Enabled:              rc.Enabled,
+		mode:                 rc.Mode,
+		notice:               notice,
+		contactURL:           rc.ContactURL,
+		objects:              objects,
+		quarantine:           rc.Quarantine,
+		quarantineBucketName: rc.QuarantineBucketName,
+		quarantineRetention:  retention,
+		keys:                 NewKeyWrapper(rc),
+	}
+}
+
+// DefaultOptions returns the options from the redactor config
+func (r *Redactor) DefaultOptions() Options {
+	return Options{Quarantine: r.quarantine}
+}
+
+// MatchEligibility returns the first eligibility policy the leak matches or
+// nil if it isn't in scope for redaction
+func MatchEligibility(policies []*config.EligibilityPolicy, leak *scanner.Leak) *config.EligibilityPolicy {
+	for _, policy := range policies {
+		if eligible(policy, leak) {
+			return policy
+		}
+	}
+
+	return nil
+}
+
+func eligible(policy *config.EligibilityPolicy, leak *scanner.Leak) bool {
+	for _, tag := range policy.IncludeTags {
+		if !slices.Contains(leak.Data.DataClasses, tag) {
+			return false
+		}
+	}
+
+	for _, tag := range policy.ExcludeTags {
+		if slices.Contains(leak.Data.DataClasses, tag) {
+			return false
+		}
+	}
+
+	if len(policy.Rules) > 0 && !slices.Contains(policy.Rules, leak.Data.RuleID) {
+		return false
+	}
+
+	if slices.Contains(policy.ExcludeRules, leak.Data.RuleID) {
+		return false
+	}
+
+	minEntropy := policy.MinEntropy
+	if ruleMinEntropy, ok := policy.RuleMinEntropy[leak.Data.RuleID]; ok {
+		minEntropy = ruleMinEntropy
+	}
+
+	return leak.Data.OffenderEntropy >= minEntropy
+}
+
+// meetsThresholds checks the leak against the redactor's entropy and
+// severity thresholds
+func meetsThresholds(rc *config.Redactor, leak *scanner.Leak) bool {
+	if leak.Data.OffenderEntropy < rc.MinEntropy {
+		return false
+	}
+
+	if len(rc.MinSeverity) > 0 {
+		return config.SeverityRank(rc.Severity(leak.Data.DataClasses)) >= config.SeverityRank(rc.MinSeverity)
 	}
+
+	return true
+}
+
+// RedactableLeaks returns the leaks that are in scope for redaction under the
+// redactor config's eligibility policies and thresholds and records the name
+// of the policy each one matched (and its severity if severities are
+// configured) on the leak. If it returns any leaks the object should be
+// redacted. Nothing is returned if there are fewer than the minimum leak
+// count so that low confidence objects are only reported.
+func RedactableLeaks(rc *config.Redactor, leaks []*scanner.Leak) []*scanner.Leak {
+	var redactableLeaks []*scanner.Leak
+	policies := rc.EligibilityPolicies()
+
+	for _, leak := range leaks {
+		if leak.Type != scanner.LeakType {
+			continue
+		}
+
+		if len(rc.Severities) > 0 {
+			leak.Data.Severity = rc.Severity(leak.Data.DataClasses)
+		}
+
+		policy := MatchEligibility(policies, leak)
+		if policy == nil || !meetsThresholds(rc, leak) {
+			continue
+		}
+
+		leak.Data.RedactionPolicy = policy.Name
+		redactableLeaks = append(redactableLeaks, leak)
+	}
+
+	if len(redactableLeaks) > 0 && len(redactableLeaks) < rc.MinLeakCount {
+		logging.Info("leaks below the redaction threshold: leak_count=%d min_leak_count=%d", len(redactableLeaks), rc.MinLeakCount)
+		for _, leak := range redactableLeaks {
+			leak.Data.RedactionPolicy = ""
+		}
+
+		return nil
+	}
+
+	return redactableLeaks
 }
 
 // Redact removes the content of the object if the redactor is enabled and if
-// quarantine is enabled, the object is first copied to the quarantine bucket.
-func (r *Redactor) Redact(ctx context.Context, objectName string, object *storage.ObjectHandle) error {
+// opts.Quarantine is set, the object is first copied to the quarantine bucket.
+// How the content is removed depends on the mode:
+//
+//   - notice replaces the full content with the rendered notice template
+//   - mask only replaces the offending secrets when it is safe to do so and
+//     falls back to notice otherwise
+//   - delete deletes the object
+//   - move always quarantines the object and then deletes it
+//
+// All reads and writes are conditioned on the generation that was scanned
+// and ErrSuperseded is returned if a newer generation has replaced it.
+func (r *Redactor) Redact(ctx context.Context, bucketName, objectName string, generation int64, leaks []*scanner.Leak, opts Options) (Result, error) {
+	var result Result
+
 	endTimer := perf.Timer("RedactObject")
 	// Added here for safey in case the conditional in the other code is
 	// removed by mistake
 	if !r.Enabled {
-		return errors.New("redact called when the redactor has been disabled")
+		return result, errors.New("redact called when the redactor has been disabled")
+	}
+
+	if r.mode == config.RedactorModeMove {
+		opts.Quarantine = true
+	}
+
+	if opts.Quarantine {
+		if len(r.quarantineBucketName) == 0 {
+			return result, errors.New("quarantine requested without a quarantine bucket")
+		}
+
+		// The copy is bounded by the caller's context and not a fixed
+		// timeout since large (and encrypted) copies can take a while
+		quarantineObjectName, err := r.copyToQuarantineBucket(ctx, bucketName, objectName, generation, leaks, "")
+		if err != nil {
+			return result, err
+		}
+
+		result.Quarantined = true
+		result.QuarantineURL = r.quarantineURL(quarantineObjectName)
 	}
 
-	if r.quarantine {
-		copyCtx, cancel := context.WithTimeout(ctx, time.Second*10)
-		defer cancel()
-		if err := r.copyToQuarantineBucket(copyCtx, objectName, object); err != nil {
-			return err
+	switch r.mode {
+	case config.RedactorModeDelete, config.RedactorModeMove:
+		logging.Info("deleting object: object_name=\"%v\"", objectName)
+		err := r.objects.Delete(ctx, bucketName, objectName, store.Conditions{GenerationMatch: generation})
+		if err != nil {
+			return result, supersededOr(fmt.Errorf("objects.Delete: %w", err))
+		}
+
+		result.Mode = r.mode
+		result.Deleted = true
+		logging.Info("object deleted: object_name=\"%v\"", objectName)
+		endTimer()
+		return result, nil
+	case config.RedactorModeMask:
+		masked, err := r.mask(ctx, bucketName, objectName, generation, leaks)
+		if err != nil {
+			return result, supersededOr(err)
+		}
+
+		if masked {
+			result.Mode = config.RedactorModeMask
+			result.Masked = true
+			endTimer()
+			return result, nil
 		}
 	}
 
+	var notice bytes.Buffer
+	err := r.notice.Execute(&notice, config.NoticeData{
+		BucketName: bucketName,
+		ObjectName: objectName,
+		Generation: generation,
+		LeakIDs:    leakIDs(leaks),
+		ContactURL: r.contactURL,
+	})
+	if err != nil {
+		return result, fmt.Errorf("notice.Execute: %w", err)
+	}
+
 	logging.Info("removing object content: object_name=\"%v\"", objectName)
-	objectWriter := object.NewWriter(ctx)
-	objectWriter.ContentType = "text/plain"
+	objectWriter := r.objects.NewWriter(ctx, bucketName, objectName, &store.ObjectAttrs{
+		ContentType: "text/plain",
+	}, store.Conditions{GenerationMatch: generation})
 
 	// Close not deferred because we want to know if it errors out after
 	// a successful write
-	_, err := objectWriter.Write([]byte(notice))
+	_, err = objectWriter.Write(notice.Bytes())
 	if err != nil {
 		_ = objectWriter.Close()
-		return fmt.Errorf("objectWriter.Write: %w", err)
+		return result, supersededOr(fmt.Errorf("objectWriter.Write: %w", err))
 	}
 
 	err = objectWriter.Close()
 	if err != nil {
-		return fmt.Errorf("objectWriter.Close: %w", err)
+		return result, supersededOr(fmt.Errorf("objectWriter.Close: %w", err))
 	}
 
+	result.Mode = config.RedactorModeNotice
+	result.Removed = true
 	logging.Info("object content removed: object_name=\"%v\"", objectName)
 	endTimer()
-	return nil
+	return result, nil
 }
 
-func (r *Redactor) copyToQuarantineBucket(ctx context.Context, objectName string, src *storage.ObjectHandle) error {
-	logging.Info("quarantining object: object_name=\"%v\"", objectName)
+// mask rewrites the object with each leak's offender replaced by a marker.
+// It returns false without changing the object if the content can't be
+// safely rewritten (e.g. it's binary, archived, encoded or too large).
+func (r *Redactor) mask(ctx context.Context, bucketName, objectName string, generation int64, leaks []*scanner.Leak) (bool, error) {
+	attrs, err := r.objects.Attrs(ctx, bucketName, objectName, generation)
+	if err != nil {
+		return false, fmt.Errorf("objects.Attrs: %w", err)
+	}
 
-	dest := r.quarantineBucket.Object(objectName)
-	// Don't write to the object if it already exists
-	dest = dest.If(storage.Conditions{DoesNotExist: true})
+	if attrs.ContentEncoding != "" || attrs.Size > maxMaskSize {
+		logging.Info("object can't be masked: object_name=%q content_encoding=%q size=%d", objectName, attrs.ContentEncoding, attrs.Size)
+		return false, nil
+	}
 
-	copier := dest.CopierFrom(src)
-	if _, err := copier.Run(ctx); err != nil {
-		return fmt.Errorf("could not copy %q: %w", objectName, err)
+	objectReader, err := r.objects.NewReader(ctx, bucketName, objectName, generation)
+	if err != nil {
+		return false, fmt.Errorf("objects.NewReader: %w", err)
 	}
 
-	logging.Info("object quarantined: object_name=\"%v\"", objectName)
-	return nil
+	content, err := io.ReadAll(io.LimitReader(objectReader, maxMaskSize+1))
+	_ = objectReader.Close()
+	if err != nil {
+		return false, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	masked, ok := maskContent(content, leaks)
+	if !ok {
+		logging.Info("object can't be masked: object_name=%q", objectName)
+		return false, nil
+	}
+
+	logging.Info("masking object content: object_name=\"%v\" leak_count=%d", objectName, len(leaks))
+	objectWriter := r.objects.NewWriter(ctx, bucketName, objectName, attrs, store.Conditions{GenerationMatch: generation})
+
+	// Close not deferred because we want to know if it errors out after
+	// a successful write
+	if _, err = objectWriter.Write(masked); err != nil {
+		_ = objectWriter.Close()
+		return false, fmt.Errorf("objectWriter.Write: %w", err)
+	}
+
+	if err = objectWriter.Close(); err != nil {
+		return false, fmt.Errorf("objectWriter.Close: %w", err)
+	}
+
+	logging.Info("object content masked: object_name=\"%v\"", objectName)
+	return true, nil
+}
+
+// maskContent replaces every offender in content with a marker containing
+// the leak's ID where the scanner found it, so the same text elsewhere in the
+// content is left alone. It returns false if the content doesn't look like
+// plain text or if any offender can't be found at its location (e.g. it was
+// found after decoding or extracting the content) since another copy of the
+// secret could be left behind.
+func maskContent(content []byte, leaks []*scanner.Leak) ([]byte, bool) {
+	if len(content) > maxMaskSize || !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		return nil, false
+	}
+
+	// Mask longer offenders first so that an offender that contains
+	// another one is still fully masked
+	sorted := make([]*scanner.Leak, len(leaks))
+	copy(sorted, leaks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Data.Offender) > len(sorted[j].Data.Offender)
+	})
+
+	text := string(content)
+	spans := make([]maskSpan, 0, len(sorted))
+	for _, leak := range sorted {
+		span, ok := leakSpan(text, leak)
+		if !ok {
+			return nil, false
+		}
+
+		spans = append(spans, span)
+	}
+
+	return []byte(maskSpans(text, spans)), true
+}
+
+// maskSpan is the part of the content to replace with a leak's marker
+type maskSpan struct {
+	start, end int
+	leakID     string
+}
+
+// leakSpan finds the leak's offender in the content from its location. The
+// columns are byte offsets for the whole match, which can be wider than the
+// offender, and gitleaks counts them from 1 on the first line of each chunk
+// it scans and from 2 after that. So the offender is looked for within the
+// match on its line instead of trusting the columns exactly.
+func leakSpan(text string, leak *scanner.Leak) (maskSpan, bool) {
+	data := leak.Data
+	if len(data.Offender) == 0 || data.LineNumber < 1 || data.EndLineNumber != data.LineNumber || data.EndColumn < 1 {
+		return maskSpan{}, false
+	}
+
+	lineStart := 0
+	for line := 1; line < data.LineNumber; line++ {
+		i := strings.IndexByte(text[lineStart:], '\n')
+		if i < 0 {
+			return maskSpan{}, false
+		}
+
+		lineStart += i + 1
+	}
+
+	line := text[lineStart:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	from, to := max(data.StartColumn-2, 0), min(data.EndColumn, len(line))
+	if from >= to {
+		return maskSpan{}, false
+	}
+
+	i := strings.Index(line[from:to], data.Offender)
+	if i < 0 {
+		return maskSpan{}, false
+	}
+
+	start := lineStart + from + i
+	return maskSpan{start: start, end: start + len(data.Offender), leakID: leak.ID}, true
+}
+
+// maskSpans replaces the spans with their markers. Spans that overlap one
+// already masked are skipped since that text is already hidden.
+func maskSpans(text string, spans []maskSpan) string {
+	var masked []maskSpan
+	for _, span := range spans {
+		overlaps := slices.ContainsFunc(masked, func(other maskSpan) bool {
+			return span.start < other.end && other.start < span.end
+		})
+
+		if !overlaps {
+			masked = append(masked, span)
+		}
+	}
+
+	sort.Slice(masked, func(i, j int) bool {
+		return masked[i].start < masked[j].start
+	})
+
+	var b strings.Builder
+	end := 0
+	for _, span := range masked {
+		b.WriteString(text[end:span.start])
+		fmt.Fprintf(&b, "REDACTED(%s)", span.leakID)
+		end = span.end
+	}
+
+	b.WriteString(text[end:])
+	return b.String()
+}
+
+func leakIDs(leaks []*scanner.Leak) []string {
+	ids := make([]string, 0, len(leaks))
+	for _, leak := range leaks {
+		ids = append(ids, leak.ID)
+	}
+
+	return ids
+}
+
+// supersededOr converts errors caused by the object being replaced or
+// removed since it was scanned into ErrSuperseded
+func supersededOr(err error) error {
+	if isSuperseded(err) {
+		return fmt.Errorf("%w: %w", ErrSuperseded, err)
+	}
+
+	return err
+}
+
+func isSuperseded(err error) bool {
+	return errors.Is(err, store.ErrNotExist) || errors.Is(err, store.ErrPreconditionFailed)
 }
@@ -2,11 +2,11 @@ package reporter
 
 import (
 	"context"
+	"fmt"
 
 	"cloud.google.com/go/bigquery"
 
 	"github.com/leaktk/gcs-filter/config"
-	"github.com/leaktk/gcs-filter/logging"
 	"github.com/leaktk/gcs-filter/perf"
 	"github.com/leaktk/gcs-filter/scanner"
 )
@@ -14,7 +14,6 @@ import (
 // BigQueryReporter stores results in BigQuery for further analysis
 type BigQueryReporter struct {
 	client   *bigquery.Client
-	ctx      context.Context
 	inserter *bigquery.Inserter
 }
 
@@ -28,18 +27,20 @@ func NewBigQueryReporter(ctx context.Context, rc *config.Reporter) (*BigQueryRep
 
 	return &BigQueryReporter{
 		client:   client,
-		ctx:      ctx,
 		inserter: client.Dataset(rc.BigQuery.DatasetID).Table(rc.BigQuery.TableID).Inserter(),
 	}, nil
 }
 
 // Report save the leak details in BigQuery
-func (r *BigQueryReporter) Report(leaks []*scanner.Leak) {
+func (r *BigQueryReporter) Report(ctx context.Context, leaks []*scanner.Leak) error {
 	endTimer := perf.Timer("ReportToBigQuery")
-	if err := r.inserter.Put(r.ctx, leaks); err != nil {
-		logging.Error("BigQuery insert failed: %w", err)
+	defer endTimer()
+
+	if err := r.inserter.Put(ctx, leaks); err != nil {
+		return fmt.Errorf("BigQuery insert failed: %w", err)
 	}
-	endTimer()
+
+	return nil
 }
 
 // Close cleans up the big query client connection
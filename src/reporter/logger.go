@@ -20,7 +20,7 @@ func NewLoggerReporter(_ context.Context, rc *config.Reporter) (*LoggerReporter,
 }
 
 // Report forwards the leak details
-func (r *LoggerReporter) Report(leaks []*scanner.Leak) {
+func (r *LoggerReporter) Report(_ context.Context, leaks []*scanner.Leak) error {
 	endTimer := perf.Timer("ReportToLogger")
 
 	for _, leak := range leaks {
@@ -35,6 +35,7 @@ func (r *LoggerReporter) Report(leaks []*scanner.Leak) {
 	}
 
 	endTimer()
+	return nil
 }
 
 // Close is only needed to implment the interface here
@@ -1,13 +1,15 @@
 package reporter
 
 import (
+	"context"
+	"errors"
 	"sync"
 
 	"github.com/leaktk/gcs-filter/scanner"
 )
 
-func wgReport(wg *sync.WaitGroup, r Reporter, leaks []*scanner.Leak) {
-	r.Report(leaks)
+func wgReport(ctx context.Context, wg *sync.WaitGroup, r Reporter, leaks []*scanner.Leak, err *error) {
+	*err = r.Report(ctx, leaks)
 	wg.Done()
 }
 
@@ -21,16 +23,19 @@ func NewMultiReporter(reporters []Reporter) (*MultiReporter, error) {
 	return &MultiReporter{reporters: reporters}, nil
 }
 
-// Report forwards leaks to the reporters
-func (r *MultiReporter) Report(leaks []*scanner.Leak) {
+// Report forwards leaks to the reporters and returns their errors joined
+// together. A failing reporter doesn't stop the others.
+func (r *MultiReporter) Report(ctx context.Context, leaks []*scanner.Leak) error {
 	var wg sync.WaitGroup
+	errs := make([]error, len(r.reporters))
 
-	for _, r := range r.reporters {
+	for i, r := range r.reporters {
 		wg.Add(1)
-		go wgReport(&wg, r, leaks)
+		go wgReport(ctx, &wg, r, leaks, &errs[i])
 	}
 
 	wg.Wait()
+	return errors.Join(errs...)
 }
 
 // Close runs close on the reporters and returns the first error it encounters
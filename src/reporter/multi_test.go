@@ -0,0 +1,69 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leaktk/gcs-filter/scanner"
+)
+
+// stubReporter counts the leaks it's sent and fails with err
+type stubReporter struct {
+	err      error
+	reported atomic.Int64
+}
+
+func (r *stubReporter) Report(_ context.Context, leaks []*scanner.Leak) error {
+	r.reported.Add(int64(len(leaks)))
+	return r.err
+}
+
+func (r *stubReporter) Close() error {
+	return nil
+}
+
+func TestMultiReporterJoinsErrors(t *testing.T) {
+	splunkErr := errors.New("splunk is down")
+	bigQueryErr := errors.New("bigquery is down")
+	reporters := []*stubReporter{{err: splunkErr}, {}, {err: bigQueryErr}}
+
+	multi, err := NewMultiReporter([]Reporter{reporters[0], reporters[1], reporters[2]})
+	require.NoError(t, err)
+
+	err = multi.Report(context.Background(), testLeaks(2))
+	assert.ErrorIs(t, err, splunkErr)
+	assert.ErrorIs(t, err, bigQueryErr)
+	for _, r := range reporters {
+		assert.Equal(t, int64(2), r.reported.Load(), "every reporter is sent the leaks")
+	}
+
+	multi, err = NewMultiReporter([]Reporter{reporters[1]})
+	require.NoError(t, err)
+	assert.NoError(t, multi.Report(context.Background(), testLeaks(1)))
+}
+
+func TestRouterReportsToTheKinds(t *testing.T) {
+	splunkErr := errors.New("splunk is down")
+	splunk, logger := &stubReporter{err: splunkErr}, &stubReporter{}
+	router := &Router{
+		defaultKinds: []string{"Logger"},
+		reporters:    map[string]Reporter{"Splunk": splunk, "Logger": logger},
+	}
+
+	require.NoError(t, router.Report(context.Background(), testLeaks(1)))
+	assert.ErrorIs(t, router.ReportTo(context.Background(), []string{"Splunk", "Logger"}, testLeaks(1)), splunkErr)
+	assert.Equal(t, int64(1), splunk.reported.Load())
+	assert.Equal(t, int64(2), logger.reported.Load())
+
+	// Kinds without a reporter fail instead of dropping the leaks
+	err := router.ReportTo(context.Background(), []string{"BigQuery", "Logger"}, testLeaks(1))
+	assert.ErrorIs(t, err, ErrNoReporter)
+	assert.ErrorContains(t, err, `kinds=["BigQuery"]`)
+	assert.Equal(t, int64(3), logger.reported.Load(), "the other kinds still get the leaks")
+	assert.ErrorIs(t, router.ReportTo(context.Background(), []string{"BigQuery"}, testLeaks(1)), ErrNoReporter)
+}
@@ -2,6 +2,7 @@ package reporter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
@@ -10,9 +11,14 @@ import (
 	"github.com/leaktk/gcs-filter/scanner"
 )
 
-// Reporter provides an interface that other reporters can implement
+// ErrNoReporter is returned when leaks are sent to a kind that has no
+// reporter set up (e.g. it failed to be set up)
+var ErrNoReporter = errors.New("no reporter is set up")
+
+// Reporter provides an interface that other reporters can implement. Report
+// returns an error if any of the leaks couldn't be reported.
 type Reporter interface {
-	Report(leaks []*scanner.Leak)
+	Report(ctx context.Context, leaks []*scanner.Leak) error
 	io.Closer
 }
 
@@ -29,24 +35,89 @@ func reporterFromKind(ctx context.Context, kind string, rc *config.Reporter) (Re
 	}
 }
 
-// NewReporter provides a concrete reporter struct based on the kind set in
-// the config
-func NewReporter(ctx context.Context, rc *config.Reporter) (Reporter, error) {
-	if len(rc.Kinds) == 1 {
-		return reporterFromKind(ctx, rc.Kinds[0], rc)
-	}
+// KindsReporter is implemented by reporters that can send leaks to only some
+// of the configured reporter kinds
+type KindsReporter interface {
+	Reporter
+	ReportTo(ctx context.Context, kinds []string, leaks []*scanner.Leak) error
+}
 
-	var reporters []Reporter
+// Router sends leaks to the reporters for the default kinds or to the ones
+// picked for a set of leaks. Each kind's reporter is only created once.
+type Router struct {
+	defaultKinds []string
+	reporters    map[string]Reporter
+}
 
-	for _, kind := range rc.Kinds {
-		rptr, err := reporterFromKind(ctx, kind, rc)
+// NewRouter sets up a reporter for each of the kinds. The kinds in the config
+// are used by default. Reporters that fail to be set up are skipped like they
+// are for a MultiReporter.
+func NewRouter(ctx context.Context, rc *config.Reporter, kinds []string) (*Router, error) {
+	router := &Router{
+		defaultKinds: rc.Kinds,
+		reporters:    make(map[string]Reporter, len(kinds)),
+	}
 
+	for _, kind := range kinds {
+		rptr, err := reporterFromKind(ctx, kind, rc)
 		if err != nil {
 			logging.Error("skipping reporter: kind=\"%s\" err=%w", kind, err)
-		} else {
+			continue
+		}
+
+		router.reporters[kind] = rptr
+	}
+
+	if len(router.reporters) == 0 && len(kinds) > 0 {
+		return nil, errors.New("none of the reporters could be set up")
+	}
+
+	return router, nil
+}
+
+// Report forwards leaks to the reporters for the default kinds
+func (r *Router) Report(ctx context.Context, leaks []*scanner.Leak) error {
+	return r.ReportTo(ctx, r.defaultKinds, leaks)
+}
+
+// ReportTo forwards leaks to the reporters for the kinds and returns their
+// errors joined together. Kinds without a reporter are returned as an error
+// wrapping ErrNoReporter.
+func (r *Router) ReportTo(ctx context.Context, kinds []string, leaks []*scanner.Leak) error {
+	var reporters []Reporter
+	var missing []string
+
+	for _, kind := range kinds {
+		if rptr, ok := r.reporters[kind]; ok {
 			reporters = append(reporters, rptr)
+		} else {
+			missing = append(missing, kind)
 		}
 	}
 
-	return NewMultiReporter(reporters)
+	// The kinds that are set up still get the leaks
+	var err error
+	if len(reporters) == 1 {
+		err = reporters[0].Report(ctx, leaks)
+	} else if len(reporters) > 1 {
+		multi := &MultiReporter{reporters: reporters}
+		err = multi.Report(ctx, leaks)
+	}
+
+	if len(missing) > 0 {
+		err = errors.Join(err, fmt.Errorf("%w: kinds=%q", ErrNoReporter, missing))
+	}
+
+	return err
+}
+
+// Close runs close on the reporters and returns the errors it encounters
+func (r *Router) Close() error {
+	var errs []error
+
+	for _, rptr := range r.reporters {
+		errs = append(errs, rptr.Close())
+	}
+
+	return errors.Join(errs...)
 }
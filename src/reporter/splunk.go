@@ -3,105 +3,626 @@ package reporter
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/leaktk/gcs-filter/config"
 	"github.com/leaktk/gcs-filter/logging"
 	"github.com/leaktk/gcs-filter/perf"
 	"github.com/leaktk/gcs-filter/scanner"
 )
 
+// Defaults for the optional Splunk settings
+const (
+	defaultSplunkBatchSize      = 32
+	defaultSplunkMaxBatchBytes  = 1024 * 1024
+	defaultSplunkRequestTimeout = 10 * time.Second
+	defaultSplunkRetryTimeout   = 60 * time.Second
+	defaultSplunkAckTimeout     = 60 * time.Second
+	defaultSplunkAckInterval    = time.Second
+)
+
+// splunkAckPath is the HEC endpoint for checking indexer acknowledgements
+const splunkAckPath = "/services/collector/ack"
+
+// splunkCollectorPaths are the HEC event endpoints the collector URL can end
+// with. They're replaced with splunkAckPath to find the ack endpoint.
+var splunkCollectorPaths = []string{"/services/collector/event", "/services/collector"}
+
+// Bounds for the delay between retries
+const (
+	splunkInitialBackoff = 250 * time.Millisecond
+	splunkMaxBackoff     = 10 * time.Second
+)
+
+// maxSplunkResponseSize limits how much of a response is read for logging
+const maxSplunkResponseSize = 64 * 1024
+
+// ErrUndelivered is returned when some of the leaks couldn't be delivered
+var ErrUndelivered = errors.New("leaks were not delivered")
+
 type splunkPayload struct {
 	Host       string        `json:"host"`
 	Index      string        `json:"index"`
 	Source     string        `json:"source"`
 	Event      *scanner.Leak `json:"event"`
 	Sourcetype string        `json:"sourcetype"`
+	// Time is the event time in seconds since the epoch. Splunk uses the
+	// time it received the event if it isn't set.
+	Time *float64 `json:"time,omitempty"`
+	// Fields are indexed so they can be searched without parsing the event
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// splunkFieldValues gets the details in config.SplunkFields from a leak
+var splunkFieldValues = map[string]func(leak *scanner.Leak) string{
+	"action":         splunkAction,
+	"bucket":         func(leak *scanner.Leak) string { return leak.Data.BucketName },
+	"generation":     func(leak *scanner.Leak) string { return generationField(leak.Data.Generation) },
+	"leak_id":        func(leak *scanner.Leak) string { return leak.ID },
+	"object":         func(leak *scanner.Leak) string { return leak.Data.FilePath },
+	"policy":         func(leak *scanner.Leak) string { return leak.Data.RedactionPolicy },
+	"quarantine_url": func(leak *scanner.Leak) string { return leak.Data.QuarantineURL },
+	"rule":           func(leak *scanner.Leak) string { return leak.Data.RuleID },
+	"severity":       func(leak *scanner.Leak) string { return leak.Data.Severity },
+	"type":           func(leak *scanner.Leak) string { return leak.Type },
+}
+
+// splunkAction is the redactor mode applied to the object or "none" if it
+// wasn't redacted
+func splunkAction(leak *scanner.Leak) string {
+	if len(leak.Data.RedactionMode) == 0 {
+		return "none"
+	}
+
+	return leak.Data.RedactionMode
+}
+
+func generationField(generation int64) string {
+	if generation == 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(generation, 10)
+}
+
+// splunkBatch is a request body holding one or more events
+type splunkBatch struct {
+	body  []byte
+	count int
+}
+
+// splunkResponse is the part of the HEC's event response that's used
+type splunkResponse struct {
+	AckID *int64 `json:"ackId"`
+}
+
+// splunkAcks is the request and response body of the ack endpoint
+type splunkAcks struct {
+	Acks any `json:"acks"`
+}
+
+// pendingAck is a delivered batch waiting to be acknowledged
+type pendingAck struct {
+	ackID int64
+	count int
+}
+
+// splunkError is a failed request and whether it's worth retrying
+type splunkError struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+func (e *splunkError) Error() string {
+	return e.err.Error()
+}
+
+func (e *splunkError) Unwrap() error {
+	return e.err
 }
 
 // SplunkReporter implements a reporter that forwards leaks to Splunk
 type SplunkReporter struct {
-	config *config.Splunk
-	client http.Client
+	config         *config.Splunk
+	client         *http.Client
+	batchSize      int
+	maxBatchBytes  int
+	requestTimeout time.Duration
+	retryTimeout   time.Duration
+	initialBackoff time.Duration
+	ack            bool
+	channel        string
+	ackURL         string
+	ackTimeout     time.Duration
+	ackInterval    time.Duration
+	fields         map[string]string
 }
 
 // NewSplunkReporter provides a configured SplunkReporter
 func NewSplunkReporter(_ context.Context, rc *config.Reporter) (*SplunkReporter, error) {
-	return &SplunkReporter{
-		config: rc.Splunk,
-		client: http.Client{
-			Timeout: 60 * time.Second,
-		},
-	}, nil
+	r := &SplunkReporter{
+		config:         rc.Splunk,
+		batchSize:      defaultSplunkBatchSize,
+		maxBatchBytes:  defaultSplunkMaxBatchBytes,
+		initialBackoff: splunkInitialBackoff,
+		ack:            rc.Splunk.Ack,
+		channel:        rc.Splunk.Channel,
+		fields:         rc.Splunk.Fields,
+	}
+
+	if len(r.fields) == 0 {
+		r.fields = make(map[string]string, len(config.DefaultSplunkFields))
+		for _, detail := range config.DefaultSplunkFields {
+			r.fields[detail] = detail
+		}
+	}
+
+	client, err := newSplunkClient(rc.Splunk)
+	if err != nil {
+		return nil, err
+	}
+
+	r.client = client
+
+	if rc.Splunk.BatchSize > 0 {
+		r.batchSize = rc.Splunk.BatchSize
+	}
+
+	if rc.Splunk.MaxBatchBytes > 0 {
+		r.maxBatchBytes = rc.Splunk.MaxBatchBytes
+	}
+
+	// The durations are checked when the config is loaded
+	var errs []error
+	r.requestTimeout, errs = durationSetting(errs, rc.Splunk.RequestTimeout, defaultSplunkRequestTimeout)
+	r.retryTimeout, errs = durationSetting(errs, rc.Splunk.RetryTimeout, defaultSplunkRetryTimeout)
+	r.ackTimeout, errs = durationSetting(errs, rc.Splunk.AckTimeout, defaultSplunkAckTimeout)
+	r.ackInterval, errs = durationSetting(errs, rc.Splunk.AckPollInterval, defaultSplunkAckInterval)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if r.ack {
+		collectorURL, err := url.Parse(rc.Splunk.Collector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid collector: %w", err)
+		}
+
+		// Acks are tied to the channel the events were sent on
+		if len(r.channel) == 0 {
+			r.channel = uuid.NewString()
+		}
+
+		collectorURL.Path = splunkAckURLPath(collectorURL.Path)
+		collectorURL.RawQuery = url.Values{"channel": {r.channel}}.Encode()
+		r.ackURL = collectorURL.String()
+	}
+
+	return r, nil
 }
 
-// Report forwards leaks to Splunk
-func (r *SplunkReporter) Report(leaks []*scanner.Leak) {
-	endTimer := perf.Timer("ReportToSplunk")
+// splunkAckURLPath swaps the HEC endpoint at the end of the collector path
+// for the ack endpoint so that any prefix (e.g. from a gateway) is kept
+func splunkAckURLPath(collectorPath string) string {
+	collectorPath = strings.TrimSuffix(collectorPath, "/")
+	for _, suffix := range splunkCollectorPaths {
+		if prefix, found := strings.CutSuffix(collectorPath, suffix); found {
+			return prefix + splunkAckPath
+		}
+	}
 
-	// Batch the uploads to reduce the risk of sending a really large payload
-	// to Splunk, but also send multiple events at one time to reduce the delay.
-	batchSize := 32
+	return splunkAckPath
+}
 
-	for start := 0; start < len(leaks); start += batchSize {
-		var events bytes.Buffer
+// stepDeadline returns when to stop a step that can take up to timeout. It's
+// never after the context's deadline so that the retries and ack polling
+// give up before the invocation is cut off.
+func stepDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
 
-		for i := start; i < len(leaks) && i-start < batchSize; i++ {
-			leak := leaks[i]
+	return deadline
+}
 
-			payload := splunkPayload{
-				Host:       r.config.Host,
-				Index:      r.config.Index,
-				Source:     r.config.Source,
-				Sourcetype: r.config.Sourcetype,
-				Event:      leak,
-			}
+// newSplunkClient provides a client with the TLS and proxy settings applied
+// to a copy of the default transport
+func newSplunkClient(cfg *config.Splunk) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(cfg.CAFile) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			logging.Warning("could not load the system CAs: err=%q", err)
+			rootCAs = x509.NewCertPool()
+		}
+
+		bundle, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA file: %w", err)
+		}
+
+		if !rootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in the CA file: path=%q", cfg.CAFile)
+		}
+
+		transport.TLSClientConfig.RootCAs = rootCAs
+	}
+
+	if len(cfg.CertFile) > 0 || len(cfg.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate: %w", err)
+		}
+
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.InsecureSkipVerify {
+		logging.Warning("the splunk collector's certificate will not be verified (LEAKTK_GCS_FILTER_SPLUNK_REPORTER_INSECURE_SKIP_VERIFY is set)")
+		transport.TLSClientConfig.InsecureSkipVerify = true // #nosec G402 -- explicitly opted into for testing
+	}
+
+	if len(cfg.ProxyURL) > 0 {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
 
-			body, err := json.Marshal(payload)
-			if err != nil {
-				logging.Error("json.Marshal: %w", err)
-				continue
+func durationSetting(errs []error, value string, fallback time.Duration) (time.Duration, []error) {
+	if len(value) == 0 {
+		return fallback, errs
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback, append(errs, err)
+	}
+
+	return duration, errs
+}
+
+// Report forwards leaks to Splunk in batches. Batches that fail with a
+// 429, a 5xx response or a network error are retried with exponential
+// backoff until the retry timeout. If acks are enabled, batches that aren't
+// acknowledged by the indexers before the ack timeout also fail. The batches
+// are sent one at a time so the retry and ack timeouts are capped by the
+// context's deadline. An error wrapping ErrUndelivered is returned if any
+// leaks couldn't be delivered.
+func (r *SplunkReporter) Report(ctx context.Context, leaks []*scanner.Leak) error {
+	endTimer := perf.Timer("ReportToSplunk")
+	defer endTimer()
+
+	batches, errs := r.batches(leaks)
+	undelivered := len(leaks)
+	for _, batch := range batches {
+		undelivered -= batch.count
+	}
+
+	var pending []pendingAck
+	for i, batch := range batches {
+		resp, err := r.send(ctx, batch)
+		if err == nil && r.ack {
+			if resp.AckID == nil {
+				err = errors.New("the response has no ackId (is indexer acknowledgement enabled for the token?)")
+			} else {
+				pending = append(pending, pendingAck{ackID: *resp.AckID, count: batch.count})
 			}
+		}
 
-			events.Write(body)
-			events.WriteString("\n")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err))
+			undelivered += batch.count
 		}
+	}
 
-		req, err := http.NewRequest("POST", r.config.Collector, bytes.NewReader(events.Bytes()))
+	if len(pending) > 0 {
+		unacked, err := r.waitForAcks(ctx, pending)
 		if err != nil {
-			logging.Error("http.Request: %w", err)
-			continue
+			errs = append(errs, err)
+		}
+
+		for _, batch := range unacked {
+			undelivered += batch.count
 		}
+	}
+
+	if undelivered > 0 {
+		return fmt.Errorf("splunk delivery failed: %w: undelivered=%d total=%d: %w", ErrUndelivered, undelivered, len(leaks), errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// batches splits the events into request bodies with at most batchSize
+// events and maxBatchBytes bytes. Batching reduces the risk of sending a
+// really large payload to Splunk while still sending multiple events at one
+// time to reduce the delay.
+func (r *SplunkReporter) batches(leaks []*scanner.Leak) ([]splunkBatch, []error) {
+	var batches []splunkBatch
+	var errs []error
+	var events bytes.Buffer
+	count := 0
 
-		req.Header.Add("Authorization", "Splunk "+r.config.Token)
-		resp, err := r.client.Do(req)
+	flush := func() {
+		if count > 0 {
+			batches = append(batches, splunkBatch{body: bytes.Clone(events.Bytes()), count: count})
+			events.Reset()
+			count = 0
+		}
+	}
 
+	for _, leak := range leaks {
+		body, err := json.Marshal(r.payload(leak))
 		if err != nil {
-			logging.Error("r.client.Do: %w", err)
+			errs = append(errs, fmt.Errorf("json.Marshal: leak_id=%q: %w", leak.ID, err))
 			continue
 		}
 
-		defer func() {
-			_ = resp.Body.Close()
-		}()
+		if count == r.batchSize || (count > 0 && events.Len()+len(body)+1 > r.maxBatchBytes) {
+			flush()
+		}
 
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			logging.Error("io.ReadAll(resp.Body): %w", err)
+		events.Write(body)
+		events.WriteString("\n")
+		count++
+	}
+
+	flush()
+	return batches, errs
+}
+
+// payload wraps the leak in a HEC event with the mapped fields and the time
+// the object was updated
+func (r *SplunkReporter) payload(leak *scanner.Leak) splunkPayload {
+	payload := splunkPayload{
+		Host:       r.config.Host,
+		Index:      r.config.Index,
+		Source:     r.config.Source,
+		Sourcetype: r.config.Sourcetype,
+		Event:      leak,
+	}
+
+	for detail, name := range r.fields {
+		// The details are checked when the config is loaded
+		value, ok := splunkFieldValues[detail]
+		if !ok {
 			continue
 		}
 
-		if resp.StatusCode >= 400 {
-			logging.Error("splunk response: status_code=%d resp=\"%s\"", resp.StatusCode, string(respBody))
+		if fieldValue := value(leak); len(fieldValue) > 0 {
+			if payload.Fields == nil {
+				payload.Fields = make(map[string]string, len(r.fields))
+			}
+
+			payload.Fields[name] = fieldValue
+		}
+	}
+
+	if updated, err := time.Parse(time.RFC3339Nano, leak.Data.ObjectUpdated); err == nil {
+		// Splunk supports millisecond precision
+		eventTime := float64(updated.UnixMilli()) / 1000
+		payload.Time = &eventTime
+	}
+
+	return payload
+}
+
+// send posts the batch and retries it until it's delivered, the error isn't
+// retryable, or the retry timeout would be exceeded
+func (r *SplunkReporter) send(ctx context.Context, batch splunkBatch) (*splunkResponse, error) {
+	deadline := stepDeadline(ctx, r.retryTimeout)
+
+	for attempt := 1; ; attempt++ {
+		respBody, err := r.post(ctx, r.config.Collector, batch.body)
+		if err == nil {
+			var resp splunkResponse
+			if err := json.Unmarshal(respBody, &resp); err != nil && r.ack {
+				return nil, fmt.Errorf("json.Unmarshal(resp.Body): %w", err)
+			}
+
+			return &resp, nil
+		}
+
+		var splunkErr *splunkError
+		if !errors.As(err, &splunkErr) || !splunkErr.retryable {
+			return nil, err
+		}
+
+		wait := r.backoff(attempt)
+		if splunkErr.retryAfter > 0 {
+			wait = splunkErr.retryAfter
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		logging.Warning("retrying splunk request: attempt=%d wait=%s err=%q", attempt, wait, err)
+		if ctxErr := sleep(ctx, wait); ctxErr != nil {
+			return nil, fmt.Errorf("gave up after %d attempts: %w", attempt, errors.Join(err, ctxErr))
+		}
+	}
+}
+
+// waitForAcks polls the ack endpoint until every batch is acknowledged by
+// the indexers or the ack timeout is reached and returns the batches that
+// weren't acknowledged. Errors checking the acks are retried until then.
+func (r *SplunkReporter) waitForAcks(ctx context.Context, pending []pendingAck) ([]pendingAck, error) {
+	deadline := stepDeadline(ctx, r.ackTimeout)
+	var lastErr error
+
+	for {
+		ackIDs := make([]int64, 0, len(pending))
+		for _, batch := range pending {
+			ackIDs = append(ackIDs, batch.ackID)
+		}
+
+		acked, err := r.queryAcks(ctx, ackIDs)
+		if err != nil {
+			logging.Warning("could not check splunk acks: err=%q", err)
+			lastErr = err
 		} else {
-			logging.Info("splunk response: status_code=%d resp=\"%s\"", resp.StatusCode, string(respBody))
+			pending = slices.DeleteFunc(pending, func(batch pendingAck) bool {
+				return acked[strconv.FormatInt(batch.ackID, 10)]
+			})
+		}
+
+		if len(pending) == 0 {
+			return nil, nil
+		}
+
+		if time.Now().Add(r.ackInterval).After(deadline) {
+			break
+		}
+
+		if ctxErr := sleep(ctx, r.ackInterval); ctxErr != nil {
+			lastErr = errors.Join(lastErr, ctxErr)
+			break
 		}
 	}
 
-	endTimer()
+	ackIDs := make([]int64, 0, len(pending))
+	for _, batch := range pending {
+		ackIDs = append(ackIDs, batch.ackID)
+	}
+
+	err := fmt.Errorf("batches not acknowledged by the indexers: channel=%q ack_ids=%v", r.channel, ackIDs)
+	if lastErr != nil {
+		err = fmt.Errorf("%w: %w", err, lastErr)
+	}
+
+	return pending, err
+}
+
+// queryAcks returns which of the ack IDs have been indexed
+func (r *SplunkReporter) queryAcks(ctx context.Context, ackIDs []int64) (map[string]bool, error) {
+	body, err := json.Marshal(splunkAcks{Acks: ackIDs})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	respBody, err := r.post(ctx, r.ackURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	acked := make(map[string]bool, len(ackIDs))
+	if err := json.Unmarshal(respBody, &splunkAcks{Acks: &acked}); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(resp.Body): %w", err)
+	}
+
+	return acked, nil
+}
+
+// sleep waits for the duration or until the context is done
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// backoff returns the delay before the next attempt. It doubles with each
+// attempt up to a limit and is jittered so that instances that failed at the
+// same time don't retry at the same time.
+func (r *SplunkReporter) backoff(attempt int) time.Duration {
+	backoff := min(r.initialBackoff<<min(attempt-1, 16), splunkMaxBackoff)
+	return backoff/2 + rand.N(backoff/2+1) // #nosec G404 -- jitter doesn't need a secure source
+}
+
+// post sends the body to the URL once with its own timeout and returns the
+// response body
+func (r *SplunkReporter) post(ctx context.Context, postURL string, body []byte) ([]byte, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, r.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, postURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	req.Header.Add("Authorization", "Splunk "+r.config.Token)
+	if len(r.channel) > 0 {
+		req.Header.Add("X-Splunk-Request-Channel", r.channel)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		// Network errors are retried unless the invocation is over or the
+		// collector's certificate isn't trusted, which won't fix itself
+		var certErr *tls.CertificateVerificationError
+		retryable := ctx.Err() == nil && !errors.As(err, &certErr)
+		return nil, &splunkError{err: fmt.Errorf("r.client.Do: %w", err), retryable: retryable}
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxSplunkResponseSize))
+	if err != nil {
+		return nil, &splunkError{err: fmt.Errorf("io.ReadAll(resp.Body): %w", err), retryable: ctx.Err() == nil}
+	}
+
+	if resp.StatusCode < 400 {
+		logging.Info("splunk response: status_code=%d resp=\"%s\"", resp.StatusCode, string(respBody))
+		return respBody, nil
+	}
+
+	logging.Error("splunk response: status_code=%d resp=\"%s\"", resp.StatusCode, string(respBody))
+	return nil, &splunkError{
+		err:        fmt.Errorf("splunk response: status_code=%d", resp.StatusCode),
+		retryable:  resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter supports both the delay in seconds and HTTP date forms of
+// the Retry-After header and returns 0 if it's missing or invalid
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
 }
 
 // Close closes any idle connections
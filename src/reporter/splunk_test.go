@@ -0,0 +1,520 @@
+package reporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leaktk/gcs-filter/config"
+	"github.com/leaktk/gcs-filter/scanner"
+)
+
+// fakeCollector is a Splunk HEC stand-in that responds with the statuses in
+// order and then with 200s
+type fakeCollector struct {
+	mu       sync.Mutex
+	statuses []int
+	headers  []http.Header
+	requests [][]byte
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var body bytes.Buffer
+	_, _ = body.ReadFrom(req.Body)
+	c.requests = append(c.requests, body.Bytes())
+
+	status := http.StatusOK
+	if len(c.statuses) > 0 {
+		status, c.statuses = c.statuses[0], c.statuses[1:]
+	}
+
+	if len(c.headers) > 0 {
+		for key, values := range c.headers[0] {
+			w.Header()[key] = values
+		}
+
+		c.headers = c.headers[1:]
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`{"text":"` + http.StatusText(status) + `"}`))
+}
+
+func (c *fakeCollector) eventCounts() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make([]int, 0, len(c.requests))
+	for _, request := range c.requests {
+		count := 0
+		scanner := bufio.NewScanner(bytes.NewReader(request))
+		for scanner.Scan() {
+			count++
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts
+}
+
+func newTestSplunkReporter(t *testing.T, collector http.Handler, splunkConfig config.Splunk) *SplunkReporter {
+	t.Helper()
+
+	server := httptest.NewServer(collector)
+	t.Cleanup(server.Close)
+
+	return newTestSplunkReporterFor(t, server.URL, splunkConfig)
+}
+
+// newTestSplunkReporterFor provides a reporter that sends to the collector
+// running at serverURL
+func newTestSplunkReporterFor(t *testing.T, serverURL string, splunkConfig config.Splunk) *SplunkReporter {
+	t.Helper()
+
+	splunkConfig.Collector = serverURL + "/services/collector"
+	splunkConfig.Token = "token"
+	r, err := NewSplunkReporter(context.Background(), &config.Reporter{Splunk: &splunkConfig})
+	require.NoError(t, err)
+	r.initialBackoff = time.Millisecond
+	t.Cleanup(func() {
+		assert.NoError(t, r.Close())
+	})
+
+	return r
+}
+
+func testLeaks(count int) []*scanner.Leak {
+	leaks := make([]*scanner.Leak, count)
+	for i := range leaks {
+		leaks[i] = &scanner.Leak{ID: "leak-" + strconv.Itoa(i), Type: scanner.LeakType}
+	}
+
+	return leaks
+}
+
+func TestSplunkReporterBatches(t *testing.T) {
+	collector := &fakeCollector{}
+	r := newTestSplunkReporter(t, collector, config.Splunk{BatchSize: 3})
+
+	require.NoError(t, r.Report(context.Background(), testLeaks(7)))
+	assert.Equal(t, []int{3, 3, 1}, collector.eventCounts())
+
+	// Set the cap so that only two events fit in a batch
+	event, err := json.Marshal(r.payload(testLeaks(1)[0]))
+	require.NoError(t, err)
+	collector = &fakeCollector{}
+	r = newTestSplunkReporter(t, collector, config.Splunk{MaxBatchBytes: 2*(len(event)+1) + len(event)/2})
+
+	require.NoError(t, r.Report(context.Background(), testLeaks(5)))
+	assert.Equal(t, []int{2, 2, 1}, collector.eventCounts())
+}
+
+func TestSplunkReporterFields(t *testing.T) {
+	leak := &scanner.Leak{ID: "leak-0", Type: scanner.LeakType}
+	leak.Data.BucketName = "uploads"
+	leak.Data.FilePath = "config.txt"
+	leak.Data.Generation = 42
+	leak.Data.RuleID = "leaktk-test-secret"
+	leak.Data.ObjectUpdated = "2024-05-01T12:30:00.25Z"
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		mode   string
+		want   map[string]string
+	}{
+		{
+			name: "Defaults",
+			want: map[string]string{
+				"bucket":  "uploads",
+				"object":  "config.txt",
+				"rule":    "leaktk-test-secret",
+				"leak_id": "leak-0",
+				"action":  "none",
+			},
+		},
+		{
+			name:   "Mapped",
+			fields: map[string]string{"bucket": "gcs_bucket", "generation": "gcs_generation", "action": "action", "severity": "severity"},
+			mode:   config.RedactorModeMask,
+			want:   map[string]string{"gcs_bucket": "uploads", "gcs_generation": "42", "action": "mask"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &fakeCollector{}
+			r := newTestSplunkReporter(t, collector, config.Splunk{Fields: tt.fields})
+			leak.Data.RedactionMode = tt.mode
+
+			require.NoError(t, r.Report(context.Background(), []*scanner.Leak{leak}))
+			require.Len(t, collector.requests, 1)
+
+			var payload struct {
+				Time   float64           `json:"time"`
+				Fields map[string]string `json:"fields"`
+			}
+			require.NoError(t, json.Unmarshal(collector.requests[0], &payload))
+			assert.Equal(t, tt.want, payload.Fields)
+			assert.InDelta(t, 1714566600.25, payload.Time, 0.0001)
+		})
+	}
+
+	// The time is left for Splunk to set if the update time isn't known
+	body, err := json.Marshal((&SplunkReporter{config: &config.Splunk{}}).payload(testLeaks(1)[0]))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), `"time"`)
+	assert.NotContains(t, string(body), `"fields"`)
+}
+
+func TestSplunkFieldValues(t *testing.T) {
+	for _, detail := range config.SplunkFields {
+		assert.Contains(t, splunkFieldValues, detail)
+	}
+
+	assert.Len(t, splunkFieldValues, len(config.SplunkFields))
+}
+
+func TestSplunkReporterRetries(t *testing.T) {
+	collector := &fakeCollector{
+		statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+	}
+	r := newTestSplunkReporter(t, collector, config.Splunk{})
+
+	require.NoError(t, r.Report(context.Background(), testLeaks(1)))
+	assert.Len(t, collector.eventCounts(), 3)
+}
+
+func TestSplunkReporterHonorsRetryAfter(t *testing.T) {
+	collector := &fakeCollector{
+		statuses: []int{http.StatusTooManyRequests},
+		headers:  []http.Header{{"Retry-After": []string{"1"}}},
+	}
+	r := newTestSplunkReporter(t, collector, config.Splunk{})
+
+	start := time.Now()
+	require.NoError(t, r.Report(context.Background(), testLeaks(1)))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Len(t, collector.eventCounts(), 2)
+
+	// Give up right away if the server asks to wait past the retry timeout
+	collector = &fakeCollector{
+		statuses: []int{http.StatusTooManyRequests},
+		headers:  []http.Header{{"Retry-After": []string{"120"}}},
+	}
+	r = newTestSplunkReporter(t, collector, config.Splunk{RetryTimeout: "5s"})
+
+	err := r.Report(context.Background(), testLeaks(1))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.Len(t, collector.eventCounts(), 1)
+}
+
+func TestSplunkReporterSurfacesUndeliveredLeaks(t *testing.T) {
+	// Client errors aren't retried but the other batches are still sent
+	collector := &fakeCollector{statuses: []int{http.StatusBadRequest}}
+	r := newTestSplunkReporter(t, collector, config.Splunk{BatchSize: 2})
+
+	err := r.Report(context.Background(), testLeaks(3))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.ErrorContains(t, err, "undelivered=2 total=3")
+	assert.ErrorContains(t, err, "status_code=400")
+	assert.Equal(t, []int{2, 1}, collector.eventCounts())
+
+	// Server errors are retried until the retry timeout
+	collector = &fakeCollector{statuses: slices.Repeat([]int{http.StatusBadGateway}, 1000)}
+	r = newTestSplunkReporter(t, collector, config.Splunk{RetryTimeout: "50ms"})
+
+	err = r.Report(context.Background(), testLeaks(1))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.ErrorContains(t, err, "gave up after")
+	assert.Greater(t, len(collector.eventCounts()), 1)
+}
+
+func TestSplunkReporterStopsWhenTheContextIsDone(t *testing.T) {
+	collector := &fakeCollector{statuses: []int{http.StatusServiceUnavailable}}
+	r := newTestSplunkReporter(t, collector, config.Splunk{})
+	r.initialBackoff = time.Second
+
+	// Cancel without a deadline so the reporter is waiting to retry
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := r.Report(ctx, testLeaks(1))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSplunkReporterGivesUpBeforeTheContextDeadline(t *testing.T) {
+	collector := &fakeCollector{
+		statuses: []int{http.StatusTooManyRequests},
+		headers:  []http.Header{{"Retry-After": []string{"2"}}},
+	}
+	r := newTestSplunkReporter(t, collector, config.Splunk{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The retry would run past the deadline so it isn't attempted
+	start := time.Now()
+	err := r.Report(ctx, testLeaks(1))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.ErrorContains(t, err, "gave up after 1 attempts")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	future := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.Greater(t, future, 50*time.Second)
+}
+
+// fakeAckCollector is a Splunk HEC stand-in with indexer acknowledgement
+// enabled. Batches are acknowledged once they've been checked indexAfter
+// times. Negative indexAfter values are never acknowledged.
+type fakeAckCollector struct {
+	mu         sync.Mutex
+	indexAfter int
+	channels   []string
+	nextAckID  int64
+	checks     map[int64]int
+}
+
+func (c *fakeAckCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	channel := req.Header.Get("X-Splunk-Request-Channel")
+	c.channels = append(c.channels, channel)
+	if len(channel) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"text":"Data channel is missing","code":10}`))
+		return
+	}
+
+	if !strings.HasSuffix(req.URL.Path, splunkAckPath) {
+		_, _ = fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, c.nextAckID)
+		c.nextAckID++
+		return
+	}
+
+	if req.URL.Query().Get("channel") != channel {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var query struct {
+		Acks []int64 `json:"acks"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	acks := make(map[string]bool, len(query.Acks))
+	for _, ackID := range query.Acks {
+		c.checks[ackID]++
+		acks[strconv.FormatInt(ackID, 10)] = c.indexAfter >= 0 && c.checks[ackID] > c.indexAfter
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]any{"acks": acks})
+}
+
+func newTestAckReporter(t *testing.T, collector *fakeAckCollector, splunkConfig config.Splunk) *SplunkReporter {
+	t.Helper()
+
+	collector.checks = make(map[int64]int)
+	splunkConfig.Ack = true
+	splunkConfig.AckPollInterval = "1ms"
+	return newTestSplunkReporter(t, collector, splunkConfig)
+}
+
+func TestSplunkReporterWaitsForAcks(t *testing.T) {
+	collector := &fakeAckCollector{indexAfter: 2}
+	r := newTestAckReporter(t, collector, config.Splunk{BatchSize: 2})
+
+	require.NoError(t, r.Report(context.Background(), testLeaks(3)))
+
+	// Every request uses the same channel
+	require.NotEmpty(t, collector.channels)
+	for _, channel := range collector.channels {
+		assert.Equal(t, r.channel, channel)
+	}
+
+	assert.Equal(t, map[int64]int{0: 3, 1: 3}, collector.checks)
+}
+
+func TestSplunkAckURLPath(t *testing.T) {
+	assert.Equal(t, "/services/collector/ack", splunkAckURLPath("/services/collector"))
+	assert.Equal(t, "/services/collector/ack", splunkAckURLPath("/services/collector/event/"))
+	assert.Equal(t, "/splunk/services/collector/ack", splunkAckURLPath("/splunk/services/collector/event"))
+	assert.Equal(t, "/splunk/services/collector/ack", splunkAckURLPath("/splunk/services/collector"))
+	assert.Equal(t, "/services/collector/ack", splunkAckURLPath(""))
+}
+
+func TestSplunkReporterAckURLKeepsThePrefix(t *testing.T) {
+	collector := &fakeAckCollector{indexAfter: 0, checks: make(map[int64]int)}
+	server := httptest.NewServer(http.StripPrefix("/splunk", collector))
+	t.Cleanup(server.Close)
+
+	splunkConfig := config.Splunk{Ack: true, AckPollInterval: "1ms"}
+	r := newTestSplunkReporterFor(t, server.URL+"/splunk", splunkConfig)
+	assert.Equal(t, server.URL+"/splunk/services/collector/ack?channel="+r.channel, r.ackURL)
+	require.NoError(t, r.Report(context.Background(), testLeaks(1)))
+	assert.Equal(t, map[int64]int{0: 1}, collector.checks)
+}
+
+func TestSplunkReporterFailsUnackedBatches(t *testing.T) {
+	collector := &fakeAckCollector{indexAfter: -1}
+	r := newTestAckReporter(t, collector, config.Splunk{
+		BatchSize:  2,
+		AckTimeout: "20ms",
+		Channel:    "0f7a3ad6-8f3c-4bb4-9b7e-5b2d0c9a4c1e",
+	})
+
+	err := r.Report(context.Background(), testLeaks(3))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.ErrorContains(t, err, "undelivered=3 total=3")
+	assert.ErrorContains(t, err, `batches not acknowledged by the indexers: channel="0f7a3ad6-8f3c-4bb4-9b7e-5b2d0c9a4c1e" ack_ids=[0 1]`)
+}
+
+func TestSplunkReporterRequiresAckIDs(t *testing.T) {
+	// A token without indexer acknowledgement doesn't return ack IDs
+	collector := &fakeCollector{}
+	r := newTestSplunkReporter(t, collector, config.Splunk{Ack: true})
+
+	err := r.Report(context.Background(), testLeaks(1))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.ErrorContains(t, err, "the response has no ackId")
+}
+
+// writePEM writes the DER bytes to a PEM file in the test's temp dir
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+// newTestClientCert creates a self-signed client certificate and returns
+// its pool along with the paths to its cert and key files
+func newTestClientCert(t *testing.T) (*x509.CertPool, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gcs-filter"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return pool, writePEM(t, "client.crt", "CERTIFICATE", der), writePEM(t, "client.key", "PRIVATE KEY", keyDER)
+}
+
+func TestSplunkReporterTLS(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewTLSServer(collector)
+	t.Cleanup(server.Close)
+
+	// The test server's certificate isn't trusted by default and it isn't
+	// worth retrying
+	r := newTestSplunkReporterFor(t, server.URL, config.Splunk{})
+	err := r.Report(context.Background(), testLeaks(1))
+	assert.ErrorIs(t, err, ErrUndelivered)
+	assert.ErrorContains(t, err, "certificate")
+	assert.NotContains(t, err.Error(), "gave up after")
+
+	caFile := writePEM(t, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+	r = newTestSplunkReporterFor(t, server.URL, config.Splunk{CAFile: caFile})
+	require.NoError(t, r.Report(context.Background(), testLeaks(1)))
+
+	r = newTestSplunkReporterFor(t, server.URL, config.Splunk{InsecureSkipVerify: true})
+	require.NoError(t, r.Report(context.Background(), testLeaks(1)))
+	assert.Equal(t, []int{1, 1}, collector.eventCounts())
+
+	_, err = NewSplunkReporter(context.Background(), &config.Reporter{
+		Splunk: &config.Splunk{Collector: server.URL, CAFile: filepath.Join(t.TempDir(), "missing.crt")},
+	})
+	assert.ErrorContains(t, err, "could not read the CA file")
+}
+
+func TestSplunkReporterClientCert(t *testing.T) {
+	clientCAs, certFile, keyFile := newTestClientCert(t)
+	collector := &fakeCollector{}
+	server := httptest.NewUnstartedServer(collector)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := writePEM(t, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+	r := newTestSplunkReporterFor(t, server.URL, config.Splunk{CAFile: caFile, RetryTimeout: "0s"})
+	assert.ErrorIs(t, r.Report(context.Background(), testLeaks(1)), ErrUndelivered)
+
+	r = newTestSplunkReporterFor(t, server.URL, config.Splunk{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, r.Report(context.Background(), testLeaks(1)))
+	assert.Equal(t, []int{1}, collector.eventCounts())
+}
+
+func TestSplunkReporterProxy(t *testing.T) {
+	collector := &fakeCollector{}
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hosts = append(hosts, req.Host)
+		collector.ServeHTTP(w, req)
+	}))
+	t.Cleanup(proxy.Close)
+
+	r := newTestSplunkReporterFor(t, "http://splunk.example.com", config.Splunk{ProxyURL: proxy.URL})
+	require.NoError(t, r.Report(context.Background(), testLeaks(1)))
+	assert.Equal(t, []string{"splunk.example.com"}, hosts)
+	assert.Equal(t, []int{1}, collector.eventCounts())
+}
@@ -1,5 +1,17 @@
 package scanner
 
+import "strconv"
+
+// Record types used in Leak.Type
+const (
+	// LeakType is used for leaks found in an object
+	LeakType = "GoogleCloudStorageLeak"
+	// ScanFailureType is used for objects that couldn't be scanned
+	ScanFailureType = "GoogleCloudStorageScanFailure"
+	// NotScannedType is used for objects that were intentionally not scanned
+	NotScannedType = "GoogleCloudStorageNotScanned"
+)
+
 type leakData struct {
 	AddedDate       string   `json:"AddedDate"`
 	DataClasses     []string `json:"DataClasses"`
@@ -10,6 +22,28 @@ type leakData struct {
 	Offender        string   `json:"Offender"`
 	OffenderEntropy float64  `json:"OffenderEntropy"`
 	Rule            string   `json:"Rule"`
+	RuleID          string   `json:"RuleID"`
+	Reason          string   `json:"Reason"`
+	// RedactionPolicy is the name of the redaction eligibility policy that
+	// put the leak in scope for redaction
+	RedactionPolicy string `json:"RedactionPolicy"`
+	// Severity is derived from the rule's tags if severities are configured
+	Severity string `json:"Severity"`
+	// RedactionMode is the redactor mode that was applied to the object
+	// (e.g. mask, notice, delete or move). It's empty if it wasn't redacted.
+	RedactionMode string `json:"RedactionMode"`
+	// QuarantineURL links the leak to the quarantined copy of the object
+	QuarantineURL string `json:"QuarantineURL"`
+	// BucketName and Generation identify the scanned object generation
+	BucketName string `json:"BucketName"`
+	Generation int64  `json:"Generation"`
+	// ObjectUpdated is when the generation was written (RFC 3339)
+	ObjectUpdated string `json:"ObjectUpdated"`
+	// EndLineNumber, StartColumn and EndColumn locate the match in the
+	// content so that it can be masked. They aren't reported.
+	EndLineNumber int `json:"-" bigquery:"-"`
+	StartColumn   int `json:"-" bigquery:"-"`
+	EndColumn     int `json:"-" bigquery:"-"`
 }
 
 // Leak contains the information from a leak formatted in a way that should be
@@ -20,21 +54,29 @@ type Leak struct {
 	Data leakData `json:"data"`
 }
 
-// IsProductionSecretRule checks the tags of a leak to see if they indicate the
-// rule is production ready
-func (l *Leak) IsProductionSecretRule() bool {
-	isSecret := false
-	isTesting := false
-
-	for _, tag := range l.Data.DataClasses {
-		if !isSecret && tag == "type:secret" {
-			isSecret = true
-		}
+func newObjectRecord(recordType, bucketName, objectName string, generation int64, reason string) *Leak {
+	url := objectURL(bucketName, objectName)
 
-		if !isTesting && tag == "group:leaktk-testing" {
-			isTesting = true
-		}
+	return &Leak{
+		ID:   leakID(url, recordType, strconv.FormatInt(generation, 10)),
+		Type: recordType,
+		Data: leakData{
+			AddedDate: now(),
+			FilePath:  objectName,
+			LeakURL:   url,
+			Reason:    reason,
+		},
 	}
+}
+
+// NewScanFailure returns a record for reporting an object that couldn't be
+// scanned so that it shows up with the leaks instead of only in the logs
+func NewScanFailure(bucketName, objectName string, generation int64, err error) *Leak {
+	return newObjectRecord(ScanFailureType, bucketName, objectName, generation, err.Error())
+}
 
-	return isSecret && !isTesting
+// NewNotScanned returns a record for reporting an object that was skipped
+// because of its size or type
+func NewNotScanned(bucketName, objectName string, generation int64, reason string) *Leak {
+	return newObjectRecord(NotScannedType, bucketName, objectName, generation, reason)
 }
@@ -3,11 +3,10 @@ package scanner
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/storage"
-
 	gitleaksconfig "github.com/zricethezav/gitleaks/v8/config"
 	"github.com/zricethezav/gitleaks/v8/detect"
 	"github.com/zricethezav/gitleaks/v8/sources"
@@ -18,13 +17,18 @@ import (
 	"github.com/cespare/xxhash/v2"
 
 	"github.com/leaktk/gcs-filter/logging"
+	"github.com/leaktk/gcs-filter/store"
 )
 
 const maxArchiveDepth = 8
 const maxDecodeDepth = 8
 
-func leakURL(bucketName, objectName string, lineNumber int) string {
-	return fmt.Sprintf("gs://%v/%v#L%d", bucketName, objectName, lineNumber)
+func objectURL(bucketName, objectName string) string {
+	return fmt.Sprintf("gs://%v/%v", bucketName, objectName)
+}
+
+func leakURL(sourceURL string, lineNumber int) string {
+	return fmt.Sprintf("%v#L%d", sourceURL, lineNumber)
 }
 
 func leakID(parts ...string) string {
@@ -38,7 +42,9 @@ func now() string {
 	return time.Now().UTC().Format(time.RFC3339)
 }
 
-func shouldSkipPath(cfg *gitleaksconfig.Config, path string) bool {
+// ShouldSkipPath returns true if the path is allowed by one of the config's
+// allowlists and shouldn't be scanned
+func ShouldSkipPath(cfg *gitleaksconfig.Config, path string) bool {
 	for _, a := range cfg.Allowlists {
 		if a.PathAllowed(path) {
 			return true
@@ -48,32 +54,49 @@ func shouldSkipPath(cfg *gitleaksconfig.Config, path string) bool {
 	return false
 }
 
-// Scan implements a subset of a no git scan to handle an object passed in
+// Scan implements a subset of a no git scan to handle an object passed in.
+// A generation of 0 scans the latest generation of the object.
 // Source: https://github.com/leaktk/gitleaks7/blob/main/scan/nogit.go
-func Scan(ctx context.Context, cfg *gitleaksconfig.Config, bucketName, objectName string, object *storage.ObjectHandle) ([]*Leak, error) {
-	var leaks []*Leak
-
-	if shouldSkipPath(cfg, objectName) {
+func Scan(ctx context.Context, cfg *gitleaksconfig.Config, objects store.ObjectStore, bucketName, objectName string, generation int64) ([]*Leak, error) {
+	if ShouldSkipPath(cfg, objectName) {
 		logging.Info("skipping because path allowed: object_name=%q", objectName)
-		return leaks, nil
+		return nil, nil
 	}
 
-	objectReader, err := object.NewReader(ctx)
+	objectReader, err := objects.NewReader(ctx, bucketName, objectName, generation)
 	if err != nil {
-		return leaks, fmt.Errorf("object.NewReader: %w", err)
+		return nil, fmt.Errorf("objects.NewReader: %w", err)
 	}
 
 	defer func() {
 		_ = objectReader.Close()
 	}()
 
+	return scanContent(ctx, cfg, objectURL(bucketName, objectName), objectName, objectReader)
+}
+
+// ScanReader runs the same scan as Scan against content that doesn't live in
+// a bucket (e.g. a local file). sourceURL is used in place of the gs:// URL
+// when building leak URLs and IDs.
+func ScanReader(ctx context.Context, cfg *gitleaksconfig.Config, sourceURL, path string, content io.Reader) ([]*Leak, error) {
+	if ShouldSkipPath(cfg, path) {
+		logging.Info("skipping because path allowed: path=%q", path)
+		return nil, nil
+	}
+
+	return scanContent(ctx, cfg, sourceURL, path, content)
+}
+
+func scanContent(ctx context.Context, cfg *gitleaksconfig.Config, sourceURL, objectName string, content io.Reader) ([]*Leak, error) {
+	var leaks []*Leak
+
 	detector := detect.NewDetector(*cfg)
 	detector.MaxArchiveDepth = maxArchiveDepth
 	detector.MaxDecodeDepth = maxDecodeDepth
 
 	file := &sources.File{
 		Config:          cfg,
-		Content:         objectReader,
+		Content:         content,
 		MaxArchiveDepth: maxArchiveDepth,
 		Path:            objectName,
 	}
@@ -81,7 +104,7 @@ func Scan(ctx context.Context, cfg *gitleaksconfig.Config, bucketName, objectNam
 	findings, err := detector.DetectSource(ctx, file)
 	seen := make(map[string]struct{})
 	for _, finding := range findings {
-		url := leakURL(bucketName, objectName, finding.StartLine)
+		url := leakURL(sourceURL, finding.StartLine)
 		id := leakID(url, finding.Match)
 
 		// Handle duplicate findings from decoding
@@ -92,7 +115,7 @@ func Scan(ctx context.Context, cfg *gitleaksconfig.Config, bucketName, objectNam
 		seen[id] = struct{}{}
 		leaks = append(leaks, &Leak{
 			ID:   id,
-			Type: "GoogleCloudStorageLeak",
+			Type: LeakType,
 			Data: leakData{
 				AddedDate:       now(),
 				DataClasses:     finding.Tags,
@@ -100,9 +123,13 @@ func Scan(ctx context.Context, cfg *gitleaksconfig.Config, bucketName, objectNam
 				LeakURL:         url,
 				Line:            finding.Line,
 				LineNumber:      finding.StartLine,
+				EndLineNumber:   finding.EndLine,
+				StartColumn:     finding.StartColumn,
+				EndColumn:       finding.EndColumn,
 				Offender:        finding.Secret,
 				OffenderEntropy: float64(finding.Entropy),
 				Rule:            finding.Description,
+				RuleID:          finding.RuleID,
 			},
 		})
 	}
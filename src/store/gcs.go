@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GCS implements ObjectStore using Google Cloud Storage
+type GCS struct {
+	client *storage.Client
+}
+
+// NewGCS returns a GCS store using the provided client
+func NewGCS(client *storage.Client) *GCS {
+	return &GCS{client: client}
+}
+
+func (s *GCS) object(bucketName, objectName string, generation int64) *storage.ObjectHandle {
+	object := s.client.Bucket(bucketName).Object(objectName)
+
+	if generation != 0 {
+		object = object.Generation(generation)
+	}
+
+	return object
+}
+
+func gcsConditions(conds Conditions) storage.Conditions {
+	return storage.Conditions{
+		GenerationMatch: conds.GenerationMatch,
+		DoesNotExist:    conds.DoesNotExist,
+	}
+}
+
+func withConditions(object *storage.ObjectHandle, conds Conditions) *storage.ObjectHandle {
+	if conds == (Conditions{}) {
+		return object
+	}
+
+	return object.If(gcsConditions(conds))
+}
+
+// gcsError maps errors from GCS to the store errors while keeping the
+// original error in the chain
+func gcsError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotExist, err)
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	}
+
+	if status.Code(err) == codes.FailedPrecondition {
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	}
+
+	return err
+}
+
+func fromGCSAttrs(attrs *storage.ObjectAttrs) *ObjectAttrs {
+	return &ObjectAttrs{
+		Bucket:             attrs.Bucket,
+		Name:               attrs.Name,
+		Generation:         attrs.Generation,
+		Size:               attrs.Size,
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		ACL:                fromGCSACL(attrs.ACL),
+		Updated:            attrs.Updated,
+		CRC32C:             attrs.CRC32C,
+	}
+}
+
+func fromGCSACL(acl []storage.ACLRule) []ACLRule {
+	var rules []ACLRule
+
+	for _, rule := range acl {
+		rules = append(rules, ACLRule{Entity: string(rule.Entity), Role: string(rule.Role)})
+	}
+
+	return rules
+}
+
+func toGCSACL(acl []ACLRule) []storage.ACLRule {
+	var rules []storage.ACLRule
+
+	for _, rule := range acl {
+		rules = append(rules, storage.ACLRule{Entity: storage.ACLEntity(rule.Entity), Role: storage.ACLRole(rule.Role)})
+	}
+
+	return rules
+}
+
+// NewReader opens a generation of an object
+func (s *GCS) NewReader(ctx context.Context, bucketName, objectName string, generation int64) (io.ReadCloser, error) {
+	reader, err := s.object(bucketName, objectName, generation).NewReader(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+
+	return reader, nil
+}
+
+// Attrs returns the attributes for a generation of an object
+func (s *GCS) Attrs(ctx context.Context, bucketName, objectName string, generation int64) (*ObjectAttrs, error) {
+	attrs, err := s.object(bucketName, objectName, generation).Attrs(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+
+	return fromGCSAttrs(attrs), nil
+}
+
+// gcsWriter maps the errors from a storage.Writer
+type gcsWriter struct {
+	writer *storage.Writer
+}
+
+func (w *gcsWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	return n, gcsError(err)
+}
+
+func (w *gcsWriter) Close() error {
+	return gcsError(w.writer.Close())
+}
+
+// NewWriter creates a writer that replaces the object's content
+func (s *GCS) NewWriter(ctx context.Context, bucketName, objectName string, attrs *ObjectAttrs, conds Conditions) io.WriteCloser {
+	writer := withConditions(s.object(bucketName, objectName, 0), conds).NewWriter(ctx)
+
+	if attrs != nil {
+		writer.CacheControl = attrs.CacheControl
+		writer.ContentDisposition = attrs.ContentDisposition
+		writer.ContentEncoding = attrs.ContentEncoding
+		writer.ContentLanguage = attrs.ContentLanguage
+		writer.ContentType = attrs.ContentType
+		writer.Metadata = attrs.Metadata
+
+		// Leave the ACL unset so the bucket's defaults are used unless one
+		// is provided
+		if len(attrs.ACL) > 0 {
+			writer.ACL = toGCSACL(attrs.ACL)
+		}
+	}
+
+	return &gcsWriter{writer: writer}
+}
+
+// Copy copies the latest generation of an object
+func (s *GCS) Copy(ctx context.Context, srcBucketName, srcObjectName, dstBucketName, dstObjectName string, opts CopyOptions) error {
+	src := withConditions(s.object(srcBucketName, srcObjectName, 0), opts.SrcConditions)
+	dst := withConditions(s.object(dstBucketName, dstObjectName, 0), opts.DstConditions)
+
+	copier := dst.CopierFrom(src)
+	if opts.Metadata != nil {
+		copier.Metadata = opts.Metadata
+	}
+
+	_, err := copier.Run(ctx)
+	return gcsError(err)
+}
+
+// Delete removes the latest generation of an object
+func (s *GCS) Delete(ctx context.Context, bucketName, objectName string, conds Conditions) error {
+	return gcsError(withConditions(s.object(bucketName, objectName, 0), conds).Delete(ctx))
+}
+
+// UpdateMetadata merges the metadata into the latest generation's metadata
+func (s *GCS) UpdateMetadata(ctx context.Context, bucketName, objectName string, metadata map[string]string, conds Conditions) error {
+	_, err := withConditions(s.object(bucketName, objectName, 0), conds).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	return gcsError(err)
+}
+
+// List calls fn for each object in the bucket under the prefix
+func (s *GCS) List(ctx context.Context, bucketName, prefix, startOffset string, fn func(*ObjectAttrs) error) error {
+	query := &storage.Query{Prefix: prefix, StartOffset: startOffset}
+	if err := query.SetAttrSelection([]string{"Bucket", "Name", "Generation", "Size", "ContentEncoding", "ContentType", "Metadata", "Updated", "CRC32C"}); err != nil {
+		return fmt.Errorf("query.SetAttrSelection: %w", err)
+	}
+
+	objects := s.client.Bucket(bucketName).Objects(ctx, query)
+	for {
+		attrs, err := objects.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("objects.Next: %w", gcsError(err))
+		}
+
+		if err := fn(fromGCSAttrs(attrs)); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the storage client
+func (s *GCS) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,294 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	attrs   ObjectAttrs
+	content []byte
+}
+
+// Memory implements ObjectStore in memory. Only the latest generation of an
+// object is kept, like a bucket without versioning. It's meant for tests and
+// local runs.
+type Memory struct {
+	mu             sync.Mutex
+	objects        map[string]*memoryObject
+	lastGeneration int64
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]*memoryObject)}
+}
+
+func memoryKey(bucketName, objectName string) string {
+	return bucketName + "/" + objectName
+}
+
+func (s *Memory) checkConditions(bucketName, objectName string, conds Conditions) error {
+	object, exists := s.objects[memoryKey(bucketName, objectName)]
+
+	if conds.DoesNotExist && exists {
+		return fmt.Errorf("%w: gs://%s/%s exists", ErrPreconditionFailed, bucketName, objectName)
+	}
+
+	if conds.GenerationMatch != 0 && (!exists || object.attrs.Generation != conds.GenerationMatch) {
+		return fmt.Errorf("%w: gs://%s/%s generation does not match %d", ErrPreconditionFailed, bucketName, objectName, conds.GenerationMatch)
+	}
+
+	return nil
+}
+
+func (s *Memory) get(bucketName, objectName string, generation int64) (*memoryObject, error) {
+	object, exists := s.objects[memoryKey(bucketName, objectName)]
+
+	if !exists || (generation != 0 && object.attrs.Generation != generation) {
+		return nil, fmt.Errorf("%w: gs://%s/%s generation=%d", ErrNotExist, bucketName, objectName, generation)
+	}
+
+	return object, nil
+}
+
+// put stores the object under a new generation. s.mu must be held.
+func (s *Memory) put(bucketName, objectName string, attrs ObjectAttrs, content []byte) int64 {
+	s.lastGeneration++
+	attrs.Bucket = bucketName
+	attrs.Name = objectName
+	attrs.Generation = s.lastGeneration
+	attrs.Size = int64(len(content))
+	attrs.Metadata = maps.Clone(attrs.Metadata)
+	attrs.ACL = slices.Clone(attrs.ACL)
+	attrs.Updated = time.Now().UTC()
+	attrs.CRC32C = crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+
+	s.objects[memoryKey(bucketName, objectName)] = &memoryObject{
+		attrs:   attrs,
+		content: bytes.Clone(content),
+	}
+
+	return attrs.Generation
+}
+
+// Put stores an object and returns its new generation
+func (s *Memory) Put(bucketName, objectName string, attrs *ObjectAttrs, content []byte) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attrs == nil {
+		attrs = &ObjectAttrs{}
+	}
+
+	return s.put(bucketName, objectName, *attrs, content)
+}
+
+// Content returns the content of the latest generation of an object
+func (s *Memory) Content(bucketName, objectName string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, err := s.get(bucketName, objectName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(object.content), nil
+}
+
+// NewReader opens a generation of an object
+func (s *Memory) NewReader(_ context.Context, bucketName, objectName string, generation int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, err := s.get(bucketName, objectName, generation)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(object.content)), nil
+}
+
+// Attrs returns the attributes for a generation of an object
+func (s *Memory) Attrs(_ context.Context, bucketName, objectName string, generation int64) (*ObjectAttrs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, err := s.get(bucketName, objectName, generation)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := object.attrs
+	attrs.Metadata = maps.Clone(attrs.Metadata)
+	attrs.ACL = slices.Clone(attrs.ACL)
+	return &attrs, nil
+}
+
+// memoryWriter buffers the content until it's closed. Like the GCS writer,
+// nothing is written if the context is canceled before it's closed.
+type memoryWriter struct {
+	ctx        context.Context
+	store      *Memory
+	bucketName string
+	objectName string
+	attrs      ObjectAttrs
+	conds      Conditions
+	buf        bytes.Buffer
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	if err := w.store.checkConditions(w.bucketName, w.objectName, w.conds); err != nil {
+		return err
+	}
+
+	w.store.put(w.bucketName, w.objectName, w.attrs, w.buf.Bytes())
+	return nil
+}
+
+// NewWriter creates a writer that replaces the object's content when closed
+func (s *Memory) NewWriter(ctx context.Context, bucketName, objectName string, attrs *ObjectAttrs, conds Conditions) io.WriteCloser {
+	writer := &memoryWriter{
+		ctx:        ctx,
+		store:      s,
+		bucketName: bucketName,
+		objectName: objectName,
+		conds:      conds,
+	}
+
+	if attrs != nil {
+		writer.attrs = ObjectAttrs{
+			CacheControl:       attrs.CacheControl,
+			ContentDisposition: attrs.ContentDisposition,
+			ContentEncoding:    attrs.ContentEncoding,
+			ContentLanguage:    attrs.ContentLanguage,
+			ContentType:        attrs.ContentType,
+			Metadata:           attrs.Metadata,
+			ACL:                attrs.ACL,
+		}
+	}
+
+	return writer
+}
+
+// Copy copies the latest generation of an object
+func (s *Memory) Copy(_ context.Context, srcBucketName, srcObjectName, dstBucketName, dstObjectName string, opts CopyOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkConditions(srcBucketName, srcObjectName, opts.SrcConditions); err != nil {
+		return err
+	}
+
+	src, err := s.get(srcBucketName, srcObjectName, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkConditions(dstBucketName, dstObjectName, opts.DstConditions); err != nil {
+		return err
+	}
+
+	attrs := src.attrs
+	if opts.Metadata != nil {
+		attrs.Metadata = opts.Metadata
+	}
+
+	s.put(dstBucketName, dstObjectName, attrs, src.content)
+	return nil
+}
+
+// Delete removes the latest generation of an object
+func (s *Memory) Delete(_ context.Context, bucketName, objectName string, conds Conditions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.get(bucketName, objectName, 0); err != nil {
+		return err
+	}
+
+	if err := s.checkConditions(bucketName, objectName, conds); err != nil {
+		return err
+	}
+
+	delete(s.objects, memoryKey(bucketName, objectName))
+	return nil
+}
+
+// UpdateMetadata merges the metadata into the latest generation's metadata
+func (s *Memory) UpdateMetadata(_ context.Context, bucketName, objectName string, metadata map[string]string, conds Conditions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, err := s.get(bucketName, objectName, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkConditions(bucketName, objectName, conds); err != nil {
+		return err
+	}
+
+	if object.attrs.Metadata == nil {
+		object.attrs.Metadata = make(map[string]string, len(metadata))
+	}
+
+	maps.Copy(object.attrs.Metadata, metadata)
+	object.attrs.Updated = time.Now().UTC()
+	return nil
+}
+
+// List calls fn for each object in the bucket under the prefix
+func (s *Memory) List(ctx context.Context, bucketName, prefix, startOffset string, fn func(*ObjectAttrs) error) error {
+	s.mu.Lock()
+	var matches []ObjectAttrs
+	for _, object := range s.objects {
+		attrs := object.attrs
+		if attrs.Bucket == bucketName && strings.HasPrefix(attrs.Name, prefix) && attrs.Name >= startOffset {
+			attrs.Metadata = maps.Clone(attrs.Metadata)
+			matches = append(matches, attrs)
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(matches, func(a, b ObjectAttrs) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	for i := range matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(&matches[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close is only needed to implement the interface here
+func (s *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotExist is returned when an object (or the requested generation of it)
+// doesn't exist
+var ErrNotExist = errors.New("object does not exist")
+
+// ErrPreconditionFailed is returned when the Conditions on an operation
+// weren't met
+var ErrPreconditionFailed = errors.New("object precondition failed")
+
+// ACLRule grants an entity (e.g. "allUsers" or "user-a@example.com") a role
+// on an object
+type ACLRule struct {
+	Entity string `json:"entity"`
+	Role   string `json:"role"`
+}
+
+// ObjectAttrs contains the attributes of an object that the app cares about
+type ObjectAttrs struct {
+	Bucket             string
+	Name               string
+	Generation         int64
+	Size               int64
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentType        string
+	Metadata           map[string]string
+	// ACL is empty for buckets with uniform bucket-level access
+	ACL     []ACLRule
+	Updated time.Time
+	// CRC32C is the Castagnoli CRC32 checksum of the content
+	CRC32C uint32
+}
+
+// Conditions limit when a write, copy or delete is allowed to happen. The zero
+// value doesn't limit anything.
+type Conditions struct {
+	// GenerationMatch requires the object's current generation to match
+	GenerationMatch int64
+	// DoesNotExist requires that the object doesn't exist
+	DoesNotExist bool
+}
+
+// CopyOptions control how an object is copied
+type CopyOptions struct {
+	// SrcConditions are applied to the source object
+	SrcConditions Conditions
+	// DstConditions are applied to the destination object
+	DstConditions Conditions
+	// Metadata replaces the source's metadata on the copy when it's not nil
+	Metadata map[string]string
+}
+
+// ObjectStore provides the object operations the scanner and redactor need
+// so that they aren't tied to a specific storage service
+type ObjectStore interface {
+	// NewReader opens a generation of an object. A generation of 0 opens the
+	// latest generation.
+	NewReader(ctx context.Context, bucketName, objectName string, generation int64) (io.ReadCloser, error)
+	// Attrs returns the attributes for a generation of an object. A
+	// generation of 0 returns the latest generation.
+	Attrs(ctx context.Context, bucketName, objectName string, generation int64) (*ObjectAttrs, error)
+	// NewWriter creates a writer that replaces the object's content when it's
+	// closed. Only the content related fields, metadata and ACL in attrs are
+	// used and errors may not be returned until Close is called.
+	NewWriter(ctx context.Context, bucketName, objectName string, attrs *ObjectAttrs, conds Conditions) io.WriteCloser
+	// Copy copies the latest generation of an object
+	Copy(ctx context.Context, srcBucketName, srcObjectName, dstBucketName, dstObjectName string, opts CopyOptions) error
+	// Delete removes the latest generation of an object
+	Delete(ctx context.Context, bucketName, objectName string, conds Conditions) error
+	// UpdateMetadata merges the metadata into the latest generation's
+	// metadata without changing its content or generation
+	UpdateMetadata(ctx context.Context, bucketName, objectName string, metadata map[string]string, conds Conditions) error
+	io.Closer
+}
+
+// Lister is implemented by stores that can list the objects in a bucket
+type Lister interface {
+	// List calls fn in lexicographical order for the latest generation of
+	// each object whose name starts with prefix and is greater than or equal
+	// to startOffset. It stops at the first error returned by fn.
+	List(ctx context.Context, bucketName, prefix, startOffset string, fn func(*ObjectAttrs) error) error
+}